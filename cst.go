@@ -0,0 +1,863 @@
+package jsonedit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// nodeKind identifies the syntactic kind of a CST node
+type nodeKind int
+
+const (
+	kindObject nodeKind = iota
+	kindArray
+	kindString
+	kindNumber
+	kindLiteral
+)
+
+func (k nodeKind) String() string {
+	switch k {
+	case kindObject:
+		return "object"
+	case kindArray:
+		return "array"
+	case kindString:
+		return "string"
+	case kindNumber:
+		return "number"
+	default:
+		return "literal"
+	}
+}
+
+// syntaxTree is the concrete syntax tree of a whole document. Together with
+// the trivia stored in its nodes it reproduces the source byte for byte.
+type syntaxTree struct {
+	data    []byte
+	lines   lineIndex
+	lead    string
+	root    *node
+	tail    string
+	format  Format
+	dialect Dialect
+	// strict is set if the input is strict JSON
+	strict bool
+}
+
+// node is a single JSON value in the concrete syntax tree. Scalars keep their
+// raw token text, containers keep their members, the trivia in front of the
+// closing bracket and whether the last member was followed by a comma.
+type node struct {
+	kind  nodeKind
+	raw   string
+	value interface{}
+	// offset and end delimit the value in the input
+	offset, end int
+
+	members []*member
+	keys    map[string]int
+	// shadowed holds the values of object members overwritten by a later
+	// member with the same key, by member index
+	shadowed      map[int]interface{}
+	closeLead     string
+	trailingComma bool
+}
+
+// member is an object member or array element together with the trivia
+// surrounding it. Comments on the same line after the comma (or after the
+// value of the last member) are kept in trail so they stay with the member.
+// For array elements the key fields are empty.
+type member struct {
+	lead      string
+	key       string
+	rawKey    string
+	keyOffset int
+	preColon  string
+	postColon string
+	value     *node
+	preComma  string
+	trail     string
+}
+
+// lookup returns the object member for key and its original position
+func (n *node) lookup(key string) (*member, int) {
+	if n == nil || n.kind != kindObject {
+		return nil, -1
+	}
+	if i, ok := n.keys[key]; ok {
+		return n.members[i], i
+	}
+	return nil, -1
+}
+
+// hasLineBreak reports whether the trivia of n or its children contains a
+// line break
+func (n *node) hasLineBreak() bool {
+	if strings.Contains(n.closeLead, "\n") {
+		return true
+	}
+	for _, m := range n.members {
+		for _, trivia := range [...]string{m.lead, m.preColon, m.postColon, m.preComma, m.trail} {
+			if strings.Contains(trivia, "\n") {
+				return true
+			}
+		}
+		if m.value.hasLineBreak() {
+			return true
+		}
+	}
+	return false
+}
+
+// element returns the array element at index i
+func (n *node) element(i int) *member {
+	if n == nil || n.kind != kindArray || i < 0 || i >= len(n.members) {
+		return nil
+	}
+	return n.members[i]
+}
+
+// matches reports whether the scalar v is equal to the value this node was
+// parsed from, in which case the raw token can be written back unchanged.
+func (n *node) matches(v interface{}) bool {
+	if n == nil {
+		return false
+	}
+	switch n.kind {
+	case kindString:
+		s, ok := v.(string)
+		return ok && s == n.value
+	case kindLiteral:
+		if v == nil {
+			return n.value == nil
+		}
+		b, ok := v.(bool)
+		return ok && n.value == b
+	case kindNumber:
+		return numberMatches(n.number(), v)
+	}
+	return false
+}
+
+// numberMatches reports whether v holds the number written as lit. Floats are
+// compared the way encoding/json would have decoded the literal, integers are
+// compared exactly.
+func numberMatches(lit string, v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(lit, rv.Type().Bits())
+		return err == nil && (f == rv.Float() || math.IsNaN(f) && math.IsNaN(rv.Float()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		r, ok := new(big.Rat).SetString(lit)
+		return ok && r.IsInt() && r.Num().IsInt64() && r.Num().Int64() == rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		r, ok := new(big.Rat).SetString(lit)
+		return ok && r.IsInt() && r.Num().IsUint64() && r.Num().Uint64() == rv.Uint()
+	case reflect.String:
+		// json.Number
+		if _, ok := v.(json.Number); !ok {
+			return false
+		}
+		if rv.String() == lit {
+			return true
+		}
+		a, ok := new(big.Rat).SetString(lit)
+		if !ok {
+			return false
+		}
+		b, ok := new(big.Rat).SetString(rv.String())
+		return ok && a.Cmp(b) == 0
+	}
+	return false
+}
+
+// parser is a hand written JSON scanner that builds the concrete syntax tree
+// and the ordered value tree in a single pass.
+type parser struct {
+	data []byte
+	pos  int
+	opts options
+
+	// path holds the object keys and array indices leading to the value
+	// being parsed, for errors
+	path []string
+
+	// depth is the nesting level of the container being parsed
+	depth int
+	scan  formatScan
+}
+
+// formatScan collects the formatting of a document while it is parsed
+type formatScan struct {
+	newline         bool
+	crlf            bool
+	indent          string
+	colonSeen       bool
+	spaceAfterColon bool
+	commaSeen       bool
+	spaceAfterComma bool
+
+	unquotedKeys, quotedKeys, single, double int
+}
+
+// member records the trivia in front of the i-th member of a container at
+// the given depth. The first indented member gives the indentation unit and
+// the first separator on a single line decides about spaces after commas.
+func (s *formatScan) member(lead string, i, depth int) {
+	nl := strings.LastIndexByte(lead, '\n')
+	if nl < 0 {
+		if i > 0 && !s.commaSeen {
+			s.commaSeen = true
+			s.spaceAfterComma = strings.HasPrefix(lead, " ")
+		}
+		return
+	}
+	if ws := lead[nl+1:]; s.indent == "" && ws != "" {
+		if len(ws)%depth == 0 {
+			ws = ws[:len(ws)/depth]
+		}
+		s.indent = ws
+	}
+}
+
+// lineBreak records whether trivia holds the first line break and if it is
+// written as CRLF
+func (s *formatScan) lineBreak(trivia string) {
+	if s.newline {
+		return
+	}
+	if i := strings.IndexByte(trivia, '\n'); i >= 0 {
+		s.newline = true
+		s.crlf = i > 0 && trivia[i-1] == '\r'
+	}
+}
+
+// colon records the trivia after the colon of an object member
+func (s *formatScan) colon(postColon string) {
+	if !s.colonSeen {
+		s.colonSeen = true
+		s.spaceAfterColon = strings.HasPrefix(postColon, " ")
+	}
+}
+
+// quote counts the quoting style of a key or string token
+func (s *formatScan) quote(raw string, key bool) {
+	switch raw[0] {
+	case '\'':
+		s.single++
+	case '"':
+		s.double++
+	default:
+		s.unquotedKeys++
+		return
+	}
+	if key {
+		s.quotedKeys++
+	}
+}
+
+// format returns the Format of the parsed document
+func (s *formatScan) format(data []byte) Format {
+	format := Format{
+		Compact:         !s.newline,
+		CRLF:            s.crlf,
+		SpaceAfterColon: s.spaceAfterColon,
+		SpaceAfterComma: s.spaceAfterComma,
+		TrailingNewline: len(data) > 0 && data[len(data)-1] == '\n',
+		UnquotedKeys:    s.unquotedKeys > s.quotedKeys,
+		SingleQuotes:    s.single > s.double,
+	}
+	if !s.commaSeen {
+		// Without separators on a single line, assume commas are spaced
+		// like colons
+		format.SpaceAfterComma = s.spaceAfterColon
+	}
+	if !format.Compact {
+		format.Indent = s.indent
+	}
+	return format
+}
+
+// parseTree parses a complete document
+func parseTree(data []byte, opts options) (*syntaxTree, interface{}, error) {
+	p := &parser{data: data, opts: opts}
+	tree := &syntaxTree{data: data, dialect: opts.dialect, strict: opts.dialect == JSON && !opts.trailingCommas}
+
+	tree.lead = p.trivia()
+	root, value, err := p.parseValue()
+	if err != nil {
+		return nil, nil, err
+	}
+	tree.root = root
+	tree.tail = p.trivia()
+	tree.format = p.scan.format(data)
+
+	if p.pos < len(p.data) {
+		return nil, nil, p.errorf("invalid character %s after top-level value", quoteChar(p.data[p.pos]))
+	}
+
+	return tree, value, nil
+}
+
+// appendCanonical appends the value as compact, strict JSON. It is used to
+// hand documents written in a relaxed dialect to encoding/json. Infinity and
+// NaN are passed on as null.
+func (n *node) appendCanonical(buf []byte) []byte {
+	switch n.kind {
+	case kindObject:
+		buf = append(buf, '{')
+		for i, m := range n.members {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if m.rawKey[0] == '"' && json.Valid([]byte(m.rawKey)) {
+				buf = append(buf, m.rawKey...)
+			} else {
+				buf = appendString(buf, m.key)
+			}
+			buf = append(buf, ':')
+			buf = m.value.appendCanonical(buf)
+		}
+		return append(buf, '}')
+	case kindArray:
+		buf = append(buf, '[')
+		for i, m := range n.members {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = m.value.appendCanonical(buf)
+		}
+		return append(buf, ']')
+	case kindString:
+		if n.raw[0] == '"' && json.Valid([]byte(n.raw)) {
+			return append(buf, n.raw...)
+		}
+		return appendString(buf, n.value.(string))
+	case kindNumber:
+		// JSON cannot represent Infinity and NaN
+		if lit, ok := canonicalNumber(n.raw); ok {
+			return append(buf, lit...)
+		}
+		return append(buf, "null"...)
+	default:
+		return append(buf, n.raw...)
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line, column := position(p.data, p.pos)
+	return &SyntaxError{
+		Msg:    fmt.Sprintf(format, args...),
+		Offset: p.pos,
+		Line:   line,
+		Column: column,
+		Path:   formatPointer(p.path),
+	}
+}
+
+func (p *parser) unexpected(context string) error {
+	if p.pos >= len(p.data) {
+		return p.errorf("unexpected end of JSON input")
+	}
+	return p.errorf("invalid character %s %s", quoteChar(p.data[p.pos]), context)
+}
+
+func quoteChar(c byte) string {
+	if c == '\'' {
+		return `'\''`
+	}
+	if c == '"' {
+		return `'"'`
+	}
+	s := strconv.Quote(string(rune(c)))
+	return "'" + s[1:len(s)-1] + "'"
+}
+
+// peek returns the current byte or 0 at the end of input
+func (p *parser) peek() byte {
+	if p.pos < len(p.data) {
+		return p.data[p.pos]
+	}
+	return 0
+}
+
+// trivia consumes insignificant whitespace and, if the dialect allows it,
+// comments. An unterminated block comment consumes the rest of the input so
+// the caller reports an unexpected end of input. Line breaks are recorded for
+// format detection.
+func (p *parser) trivia() string {
+	t := p.scanTrivia()
+	p.scan.lineBreak(t)
+	return t
+}
+
+// scanTrivia consumes trivia without recording it
+func (p *parser) scanTrivia() string {
+	start := p.pos
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		case '/':
+			if !p.opts.comments() || p.pos+1 >= len(p.data) {
+				return string(p.data[start:p.pos])
+			}
+			switch p.data[p.pos+1] {
+			case '/':
+				if end := bytes.IndexByte(p.data[p.pos:], '\n'); end >= 0 {
+					p.pos += end
+				} else {
+					p.pos = len(p.data)
+				}
+			case '*':
+				if end := bytes.Index(p.data[p.pos+2:], []byte("*/")); end >= 0 {
+					p.pos += end + 4
+				} else {
+					p.pos = len(p.data)
+				}
+			default:
+				return string(p.data[start:p.pos])
+			}
+		default:
+			if !p.opts.json5() {
+				return string(p.data[start:p.pos])
+			}
+			r, size := utf8.DecodeRune(p.data[p.pos:])
+			if !json5Space(r) {
+				return string(p.data[start:p.pos])
+			}
+			p.pos += size
+		}
+	}
+	return string(p.data[start:p.pos])
+}
+
+// comments returns the comments contained in trivia
+func comments(trivia string) []string {
+	var cs []string
+	for i := 0; i < len(trivia); i++ {
+		if trivia[i] != '/' || i+1 >= len(trivia) {
+			continue
+		}
+		end := len(trivia)
+		switch trivia[i+1] {
+		case '/':
+			if j := strings.IndexByte(trivia[i:], '\n'); j >= 0 {
+				end = i + j
+			}
+			cs = append(cs, strings.TrimRight(trivia[i:end], "\r"))
+		case '*':
+			if j := strings.Index(trivia[i+2:], "*/"); j >= 0 {
+				end = i + j + 4
+			}
+			cs = append(cs, trivia[i:end])
+		default:
+			continue
+		}
+		i = end - 1
+	}
+	return cs
+}
+
+// splitTrail splits the trivia following a member into the comments that are
+// on the same line as the member and the remainder, which belongs to the next
+// member or the closing bracket.
+func splitTrail(trivia string) (string, string) {
+	end := 0
+	for i := 0; i < len(trivia); i++ {
+		switch c := trivia[i]; {
+		case c == '\n' || c == '\r':
+			return trivia[:end], trivia[end:]
+		case c == '/' && i+1 < len(trivia) && trivia[i+1] == '/':
+			j := strings.IndexAny(trivia[i:], "\r\n")
+			if j < 0 {
+				return trivia, ""
+			}
+			return trivia[:i+j], trivia[i+j:]
+		case c == '/' && i+1 < len(trivia) && trivia[i+1] == '*':
+			j := strings.Index(trivia[i:], "*/")
+			if j < 0 || strings.ContainsAny(trivia[i:i+j], "\r\n") {
+				return trivia[:end], trivia[end:]
+			}
+			i += j + 1
+			end = i + 1
+		}
+	}
+	if end == 0 {
+		return "", trivia
+	}
+	return trivia[:end], trivia[end:]
+}
+
+// endsWithLineComment reports whether a line break must follow trivia
+func endsWithLineComment(trivia string) bool {
+	cs := comments(trivia)
+	return len(cs) > 0 && strings.HasPrefix(cs[len(cs)-1], "//") &&
+		strings.HasSuffix(strings.TrimRight(trivia, " \t\r"), cs[len(cs)-1])
+}
+
+// parseValue parses any JSON value
+func (p *parser) parseValue() (*node, interface{}, error) {
+	offset := p.pos
+	n, value, err := p.parseToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	n.offset, n.end = offset, p.pos
+	return n, value, nil
+}
+
+// parseToken parses the value starting at the current position
+func (p *parser) parseToken() (*node, interface{}, error) {
+	if p.opts.json5() {
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			s, raw, err := p.parseString5()
+			if err != nil {
+				return nil, nil, err
+			}
+			p.scan.quote(raw, false)
+			return &node{kind: kindString, raw: raw, value: s}, s, nil
+		case c == '-' || c == '+' || c == '.' || c == 'I' || c == 'N' || isDigit(c):
+			return p.parseNumber5()
+		}
+	}
+
+	switch c := p.peek(); {
+	case c == '{':
+		return p.parseObject()
+	case c == '[':
+		return p.parseArray()
+	case c == '"':
+		s, raw, err := p.parseString()
+		if err != nil {
+			return nil, nil, err
+		}
+		p.scan.quote(raw, false)
+		return &node{kind: kindString, raw: raw, value: s}, s, nil
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case c == 't':
+		return p.parseLiteral("true", true)
+	case c == 'f':
+		return p.parseLiteral("false", false)
+	case c == 'n':
+		return p.parseLiteral("null", nil)
+	default:
+		return nil, nil, p.unexpected("looking for beginning of value")
+	}
+}
+
+// parseObject parses a JSON object preserving key order
+func (p *parser) parseObject() (*node, interface{}, error) {
+	n := &node{kind: kindObject, keys: make(map[string]int)}
+	om := NewOrderedMap()
+	om.node = n
+	p.pos++
+	p.depth++
+
+	for {
+		lead := p.trivia()
+		if len(n.members) > 0 {
+			prev := n.members[len(n.members)-1]
+			prev.trail, lead = splitTrail(lead)
+		}
+		if p.peek() == '}' && (len(n.members) == 0 || p.opts.allowTrailingCommas()) {
+			n.trailingComma = len(n.members) > 0
+			n.closeLead = lead
+			p.pos++
+			p.depth--
+			return n, om, nil
+		}
+		p.scan.member(lead, len(n.members), p.depth)
+		m := &member{lead: lead, keyOffset: p.pos}
+		var err error
+		switch c := p.peek(); {
+		case c == '"' && !p.opts.json5():
+			m.key, m.rawKey, err = p.parseString()
+		case (c == '"' || c == '\'') && p.opts.json5():
+			m.key, m.rawKey, err = p.parseString5()
+		case p.opts.json5():
+			m.key, m.rawKey, err = p.parseIdentifier()
+		default:
+			err = p.unexpected("looking for beginning of object key string")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		key := m.key
+		p.scan.quote(m.rawKey, true)
+
+		m.preColon = p.trivia()
+		if p.peek() != ':' {
+			return nil, nil, p.unexpected("after object key")
+		}
+		p.pos++
+		m.postColon = p.trivia()
+		p.scan.colon(m.postColon)
+
+		p.path = append(p.path, key)
+		valueNode, value, err := p.parseValue()
+		if err != nil {
+			return nil, nil, err
+		}
+		p.path = p.path[:len(p.path)-1]
+		m.value = valueNode
+
+		// A repeated key overwrites the value and moves to the place of the
+		// last member, the earlier one is kept to write it as it was
+		if prev, ok := n.keys[key]; ok {
+			if n.shadowed == nil {
+				n.shadowed = make(map[int]interface{})
+			}
+			n.shadowed[prev], _ = om.Get(key)
+			om.Delete(key)
+		}
+		n.keys[key] = len(n.members)
+		n.members = append(n.members, m)
+		om.Set(key, value, len(n.members)-1)
+
+		after := p.trivia()
+		switch p.peek() {
+		case ',':
+			m.preComma = after
+			p.pos++
+		case '}':
+			m.trail, n.closeLead = splitTrail(after)
+			p.pos++
+			p.depth--
+			return n, om, nil
+		default:
+			return nil, nil, p.unexpected("after object key:value pair")
+		}
+	}
+}
+
+// parseArray parses a JSON array
+func (p *parser) parseArray() (*node, interface{}, error) {
+	n := &node{kind: kindArray}
+	var arr []interface{}
+	p.pos++
+	p.depth++
+
+	for {
+		lead := p.trivia()
+		if len(n.members) > 0 {
+			prev := n.members[len(n.members)-1]
+			prev.trail, lead = splitTrail(lead)
+		}
+		if p.peek() == ']' && (len(n.members) == 0 || p.opts.allowTrailingCommas()) {
+			n.trailingComma = len(n.members) > 0
+			n.closeLead = lead
+			p.pos++
+			p.depth--
+			return n, arr, nil
+		}
+
+		p.scan.member(lead, len(n.members), p.depth)
+		m := &member{lead: lead}
+		p.path = append(p.path, strconv.Itoa(len(n.members)))
+		valueNode, value, err := p.parseValue()
+		if err != nil {
+			return nil, nil, err
+		}
+		p.path = p.path[:len(p.path)-1]
+		m.value = valueNode
+
+		n.members = append(n.members, m)
+		arr = append(arr, value)
+
+		after := p.trivia()
+		switch p.peek() {
+		case ',':
+			m.preComma = after
+			p.pos++
+		case ']':
+			m.trail, n.closeLead = splitTrail(after)
+			p.pos++
+			p.depth--
+			return n, arr, nil
+		default:
+			return nil, nil, p.unexpected("after array element")
+		}
+	}
+}
+
+// parseLiteral parses true, false or null
+func (p *parser) parseLiteral(lit string, value interface{}) (*node, interface{}, error) {
+	for i := 0; i < len(lit); i++ {
+		if p.pos >= len(p.data) || p.data[p.pos] != lit[i] {
+			return nil, nil, p.unexpected("in literal " + lit)
+		}
+		p.pos++
+	}
+	return &node{kind: kindLiteral, raw: lit, value: value}, value, nil
+}
+
+// parseNumber parses a JSON number literal
+func (p *parser) parseNumber() (*node, interface{}, error) {
+	start := p.pos
+
+	if p.peek() == '-' {
+		p.pos++
+	}
+	switch c := p.peek(); {
+	case c == '0':
+		p.pos++
+	case isDigit(c):
+		p.digits()
+	default:
+		return nil, nil, p.unexpected("in numeric literal")
+	}
+	if p.peek() == '.' {
+		p.pos++
+		if !isDigit(p.peek()) {
+			return nil, nil, p.unexpected("after decimal point in numeric literal")
+		}
+		p.digits()
+	}
+	if c := p.peek(); c == 'e' || c == 'E' {
+		p.pos++
+		if c := p.peek(); c == '+' || c == '-' {
+			p.pos++
+		}
+		if !isDigit(p.peek()) {
+			return nil, nil, p.unexpected("in exponent of numeric literal")
+		}
+		p.digits()
+	}
+
+	raw := string(p.data[start:p.pos])
+	value := numberValue(raw)
+	return &node{kind: kindNumber, raw: raw, value: value}, value, nil
+}
+
+// numberValue converts a number literal to the value stored in the ordered
+// tree. The literal is kept as is, so no precision or spelling is lost.
+func numberValue(raw string) interface{} {
+	return json.Number(raw)
+}
+
+func (p *parser) digits() {
+	for isDigit(p.peek()) {
+		p.pos++
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parseString parses a quoted string and returns the decoded and raw text
+func (p *parser) parseString() (string, string, error) {
+	start := p.pos
+	p.pos++
+	escaped := false
+
+	for {
+		if p.pos >= len(p.data) {
+			return "", "", p.errorf("unexpected end of JSON input")
+		}
+		c := p.data[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			raw := string(p.data[start:p.pos])
+			if !escaped && utf8.ValidString(raw) {
+				return raw[1 : len(raw)-1], raw, nil
+			}
+			return unquote(raw[1 : len(raw)-1]), raw, nil
+		case c == '\\':
+			escaped = true
+			p.pos++
+			switch p.peek() {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				p.pos++
+			case 'u':
+				p.pos++
+				for i := 0; i < 4; i++ {
+					if !isHex(p.peek()) {
+						return "", "", p.unexpected("in \\u hexadecimal character escape")
+					}
+					p.pos++
+				}
+			default:
+				return "", "", p.unexpected("in string escape code")
+			}
+		case c < 0x20:
+			return "", "", p.unexpected("in string literal")
+		default:
+			p.pos++
+		}
+	}
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// unquote decodes the escape sequences of an already validated string body.
+// Invalid UTF-8 and unpaired surrogates become U+FFFD like in encoding/json.
+func unquote(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c != '\\' {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			b.WriteRune(r)
+			i += size
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			r := hexRune(s[i+1 : i+5])
+			i += 4
+			if utf16.IsSurrogate(r) {
+				// The low half follows as \uXXXX right after the high one
+				high := r
+				r = utf8.RuneError
+				if i+7 <= len(s) && strings.HasPrefix(s[i+1:], `\u`) {
+					if r2 := utf16.DecodeRune(high, hexRune(s[i+3:i+7])); r2 != utf8.RuneError {
+						r = r2
+						i += 6
+					}
+				}
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte(s[i])
+		}
+		i++
+	}
+
+	return b.String()
+}
+
+func hexRune(s string) rune {
+	v, _ := strconv.ParseUint(s, 16, 32)
+	return rune(v)
+}
@@ -0,0 +1,608 @@
+package jsonedit
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	numberType          = reflect.TypeFor[json.Number]()
+)
+
+// decoder populates typed data from the syntax tree following the rules of
+// json.Unmarshal, so that the input does not have to be decoded twice.
+// Infinity and NaN of JSON5 are stored in floats and decode as null into
+// everything else.
+type decoder struct {
+	data       []byte
+	strict     bool
+	savedError error
+
+	// Innermost struct and JSON field path for type errors
+	errorStruct reflect.Type
+	errorPath   []string
+	// Value being decoded and the keys and indices leading to it in the
+	// input, to locate type errors
+	node *node
+	path []string
+}
+
+// decodeTree decodes the document into v, which must be a non-nil pointer
+func decodeTree(tree *syntaxTree, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &json.InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+
+	d := &decoder{
+		data:   tree.data,
+		strict: tree.strict,
+		// Room for the paths of usual nesting depths
+		errorPath: make([]string, 0, 8),
+		path:      make([]string, 0, 8),
+	}
+	if err := d.value(tree.root, rv); err != nil {
+		return err
+	}
+	return d.savedError
+}
+
+// source returns the input of n for json.Unmarshaler. Strict JSON is passed
+// as it is like json.Unmarshal does, relaxed syntax in canonical form.
+func (d *decoder) source(n *node) []byte {
+	if d.strict {
+		return d.data[n.offset:n.end:n.end]
+	}
+	return n.appendCanonical(nil)
+}
+
+// saveError keeps the first error that does not stop decoding
+func (d *decoder) saveError(err error) {
+	if d.savedError != nil {
+		return
+	}
+	te, ok := err.(*json.UnmarshalTypeError)
+	if !ok || d.node == nil {
+		d.savedError = err
+		return
+	}
+	if d.errorStruct != nil {
+		te.Struct = d.errorStruct.Name()
+		te.Field = strings.Join(d.errorPath, ".")
+	}
+	te.Offset = int64(d.node.offset)
+	line, column := position(d.data, d.node.offset)
+	d.savedError = &TypeError{
+		Value:  te.Value,
+		Type:   te.Type,
+		Offset: d.node.offset,
+		Line:   line,
+		Column: column,
+		Path:   formatPointer(d.path),
+		err:    te,
+	}
+}
+
+func (d *decoder) value(n *node, v reflect.Value) error {
+	if !v.IsValid() {
+		return nil
+	}
+	d.node = n
+	switch n.kind {
+	case kindObject:
+		return d.object(n, v)
+	case kindArray:
+		return d.array(n, v)
+	default:
+		return d.literalStore(n, v, false)
+	}
+}
+
+// indirect walks down v allocating pointers as needed until it reaches a
+// non-pointer. It stops early at a json.Unmarshaler or
+// encoding.TextUnmarshaler. When decoding null it stops at the last settable
+// pointer so that it can be set to nil.
+func indirect(v reflect.Value, decodingNull bool) (json.Unmarshaler, encoding.TextUnmarshaler, reflect.Value) {
+	// Named non-pointer types may implement the interfaces on their pointer
+	v0 := v
+	haveAddr := false
+	if v.Kind() != reflect.Pointer && v.Type().Name() != "" && v.CanAddr() {
+		haveAddr = true
+		v = v.Addr()
+	}
+
+	for {
+		// Load value from interface, but only if the result will be
+		// usefully addressable
+		if v.Kind() == reflect.Interface && !v.IsNil() {
+			e := v.Elem()
+			if e.Kind() == reflect.Pointer && !e.IsNil() && (!decodingNull || e.Elem().Kind() == reflect.Pointer) {
+				haveAddr = false
+				v = e
+				continue
+			}
+		}
+
+		if v.Kind() != reflect.Pointer {
+			break
+		}
+		if decodingNull && v.CanSet() {
+			break
+		}
+		// Prevent infinite loops on a pointer stored in an interface that
+		// points back to itself
+		if v.Elem().Kind() == reflect.Interface && v.Elem().Elem().Equal(v) {
+			v = v.Elem()
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if v.Type().NumMethod() > 0 && v.CanInterface() {
+			if u, ok := v.Interface().(json.Unmarshaler); ok {
+				return u, nil, reflect.Value{}
+			}
+			if !decodingNull {
+				if u, ok := v.Interface().(encoding.TextUnmarshaler); ok {
+					return nil, u, reflect.Value{}
+				}
+			}
+		}
+
+		if haveAddr {
+			v = v0
+			haveAddr = false
+		} else {
+			v = v.Elem()
+		}
+	}
+	return nil, nil, v
+}
+
+func (d *decoder) object(n *node, v reflect.Value) error {
+	u, ut, pv := indirect(v, false)
+	if u != nil {
+		return u.UnmarshalJSON(d.source(n))
+	}
+	if ut != nil {
+		d.saveError(&json.UnmarshalTypeError{Value: "object", Type: v.Type()})
+		return nil
+	}
+	v = pv
+	t := v.Type()
+
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		v.Set(reflect.ValueOf(d.objectInterface(n)))
+		return nil
+	}
+
+	var fields structFields
+	switch v.Kind() {
+	case reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !reflect.PointerTo(t.Key()).Implements(textUnmarshalerType) {
+				d.saveError(&json.UnmarshalTypeError{Value: "object", Type: t})
+				return nil
+			}
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+	case reflect.Struct:
+		fields = cachedTypeFields(t)
+	default:
+		d.saveError(&json.UnmarshalTypeError{Value: "object", Type: t})
+		return nil
+	}
+
+	var mapElem, mapKey reflect.Value
+	origStruct, origDepth := d.errorStruct, len(d.errorPath)
+	pathDepth := len(d.path)
+	for _, m := range n.members {
+		var subv reflect.Value
+		destring := false
+		d.path = append(d.path, m.key)
+
+		if v.Kind() == reflect.Map {
+			if !mapElem.IsValid() {
+				mapElem = reflect.New(t.Elem()).Elem()
+				mapKey = reflect.New(t.Key()).Elem()
+			} else {
+				mapElem.SetZero()
+			}
+			subv = mapElem
+			d.errorPath = append(d.errorPath, m.key)
+		} else if f := fields.lookup(m.key); f != nil {
+			subv = v
+			destring = f.quoted
+			for _, i := range f.index {
+				if subv.Kind() == reflect.Pointer {
+					if subv.IsNil() {
+						// Pointers to embedded structs that are unexported
+						// cannot be allocated
+						if !subv.CanSet() {
+							d.saveError(fmt.Errorf("json: cannot set embedded pointer to unexported struct: %v", subv.Type().Elem()))
+							subv = reflect.Value{}
+							destring = false
+							break
+						}
+						subv.Set(reflect.New(subv.Type().Elem()))
+					}
+					subv = subv.Elem()
+				}
+				subv = subv.Field(i)
+			}
+			d.errorPath = append(d.errorPath, f.name)
+			d.errorStruct = t
+		}
+
+		if destring {
+			if err := d.valueQuoted(m.value, subv); err != nil {
+				return err
+			}
+		} else if err := d.value(m.value, subv); err != nil {
+			return err
+		}
+
+		if v.Kind() == reflect.Map {
+			d.node = m.value
+			if d.mapKey(m.key, mapKey) {
+				v.SetMapIndex(mapKey, subv)
+			}
+		}
+
+		d.errorStruct, d.errorPath = origStruct, d.errorPath[:origDepth]
+		d.path = d.path[:pathDepth]
+	}
+	return nil
+}
+
+// valueQuoted decodes a value of a field with the string option, which holds
+// the JSON encoding of the field inside a string
+func (d *decoder) valueQuoted(n *node, v reflect.Value) error {
+	d.node = n
+	switch {
+	case n.kind == kindLiteral && n.value == nil:
+		return d.literalStore(n, v, false)
+	case n.kind != kindString:
+		d.saveError(fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal unquoted value into %v", v.Type()))
+		return nil
+	}
+
+	s := n.value.(string)
+	if s == "" {
+		d.saveError(fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal %q into %v", s, v.Type()))
+		return nil
+	}
+	p := &parser{data: []byte(s)}
+	inner, _, err := p.parseValue()
+	if err != nil || p.pos < len(p.data) || inner.kind == kindObject || inner.kind == kindArray {
+		if c := s[0]; c == '-' || isDigit(c) {
+			d.saveError(&json.UnmarshalTypeError{Value: "number " + s, Type: v.Type()})
+			return nil
+		}
+		return fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal %q into %v", s, v.Type())
+	}
+	return d.literalStore(inner, v, true)
+}
+
+// mapKey converts an object key to the map key kv and reports whether it
+// could. kv is reused for all keys of a map.
+func (d *decoder) mapKey(key string, kv reflect.Value) bool {
+	kt := kv.Type()
+	if reflect.PointerTo(kt).Implements(textUnmarshalerType) {
+		kv.SetZero()
+		if err := kv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key)); err != nil {
+			d.saveError(err)
+			return false
+		}
+		return true
+	}
+
+	switch kt.Kind() {
+	case reflect.String:
+		kv.SetString(key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(key, 10, 64)
+		if err != nil || kv.OverflowInt(n) {
+			d.saveError(&json.UnmarshalTypeError{Value: "number " + key, Type: kt})
+			return false
+		}
+		kv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(key, 10, 64)
+		if err != nil || kv.OverflowUint(n) {
+			d.saveError(&json.UnmarshalTypeError{Value: "number " + key, Type: kt})
+			return false
+		}
+		kv.SetUint(n)
+	default:
+		return false
+	}
+	return true
+}
+
+func (d *decoder) array(n *node, v reflect.Value) error {
+	u, ut, pv := indirect(v, false)
+	if u != nil {
+		return u.UnmarshalJSON(d.source(n))
+	}
+	if ut != nil {
+		d.saveError(&json.UnmarshalTypeError{Value: "array", Type: v.Type()})
+		return nil
+	}
+	v = pv
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(d.arrayInterface(n)))
+			return nil
+		}
+		d.saveError(&json.UnmarshalTypeError{Value: "array", Type: v.Type()})
+		return nil
+	case reflect.Array, reflect.Slice:
+	default:
+		d.saveError(&json.UnmarshalTypeError{Value: "array", Type: v.Type()})
+		return nil
+	}
+
+	depth := len(d.errorPath)
+	i := 0
+	for _, m := range n.members {
+		// Expand slice length, growing the slice if necessary
+		if v.Kind() == reflect.Slice {
+			if i >= v.Cap() {
+				v.Grow(1)
+			}
+			if i >= v.Len() {
+				v.SetLen(i + 1)
+			}
+		}
+		// Elements beyond the length of an array are ignored
+		if i < v.Len() {
+			d.errorPath = append(d.errorPath, strconv.Itoa(i))
+			d.path = append(d.path, d.errorPath[depth])
+			if err := d.value(m.value, v.Index(i)); err != nil {
+				return err
+			}
+			d.errorPath = d.errorPath[:depth]
+			d.path = d.path[:len(d.path)-1]
+		}
+		i++
+	}
+
+	if i < v.Len() {
+		if v.Kind() == reflect.Array {
+			for ; i < v.Len(); i++ {
+				v.Index(i).SetZero()
+			}
+		} else {
+			v.SetLen(i)
+		}
+	}
+	if i == 0 && v.Kind() == reflect.Slice {
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
+	return nil
+}
+
+// literalStore decodes the scalar n into v. fromQuoted is set for values
+// taken from a string of a field with the string option.
+func (d *decoder) literalStore(n *node, v reflect.Value, fromQuoted bool) error {
+	isNull := n.kind == kindLiteral && n.value == nil
+	u, ut, pv := indirect(v, isNull)
+	if u != nil {
+		return u.UnmarshalJSON(d.source(n))
+	}
+	if ut != nil {
+		if n.kind != kindString {
+			if fromQuoted {
+				return fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal %q into %v", n.raw, v.Type())
+			}
+			d.saveError(&json.UnmarshalTypeError{Value: literalName(n), Type: v.Type()})
+			return nil
+		}
+		return ut.UnmarshalText([]byte(n.value.(string)))
+	}
+	v = pv
+
+	switch n.kind {
+	case kindLiteral:
+		value, isBool := n.value.(bool)
+		if !isBool {
+			// null
+			switch v.Kind() {
+			case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+				v.SetZero()
+			}
+			return nil
+		}
+		switch v.Kind() {
+		case reflect.Bool:
+			v.SetBool(value)
+		case reflect.Interface:
+			if v.NumMethod() == 0 {
+				v.Set(reflect.ValueOf(value))
+				return nil
+			}
+			d.saveError(&json.UnmarshalTypeError{Value: "bool", Type: v.Type()})
+		default:
+			if fromQuoted {
+				return fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal %q into %v", n.raw, v.Type())
+			}
+			d.saveError(&json.UnmarshalTypeError{Value: "bool", Type: v.Type()})
+		}
+
+	case kindString:
+		s := n.value.(string)
+		switch v.Kind() {
+		case reflect.Slice:
+			if v.Type().Elem().Kind() != reflect.Uint8 {
+				d.saveError(&json.UnmarshalTypeError{Value: "string", Type: v.Type()})
+				return nil
+			}
+			b := make([]byte, base64.StdEncoding.DecodedLen(len(s)))
+			n, err := base64.StdEncoding.Decode(b, []byte(s))
+			if err != nil {
+				d.saveError(err)
+				return nil
+			}
+			v.SetBytes(b[:n])
+		case reflect.String:
+			if v.Type() == numberType && !isValidNumber(s) {
+				return fmt.Errorf("json: invalid number literal, trying to unmarshal %q into Number", n.raw)
+			}
+			v.SetString(s)
+		case reflect.Interface:
+			if v.NumMethod() == 0 {
+				v.Set(reflect.ValueOf(s))
+				return nil
+			}
+			d.saveError(&json.UnmarshalTypeError{Value: "string", Type: v.Type()})
+		default:
+			d.saveError(&json.UnmarshalTypeError{Value: "string", Type: v.Type()})
+		}
+
+	case kindNumber:
+		s, ok := canonicalNumber(n.raw)
+		if !ok {
+			return d.nonFinite(n.value.(float64), v)
+		}
+		switch v.Kind() {
+		case reflect.Interface:
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				d.saveError(&json.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeFor[float64]()})
+				return nil
+			}
+			if v.NumMethod() != 0 {
+				d.saveError(&json.UnmarshalTypeError{Value: "number", Type: v.Type()})
+				return nil
+			}
+			v.Set(reflect.ValueOf(f))
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || v.OverflowInt(i) {
+				d.saveError(&json.UnmarshalTypeError{Value: "number " + s, Type: v.Type()})
+				return nil
+			}
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			i, err := strconv.ParseUint(s, 10, 64)
+			if err != nil || v.OverflowUint(i) {
+				d.saveError(&json.UnmarshalTypeError{Value: "number " + s, Type: v.Type()})
+				return nil
+			}
+			v.SetUint(i)
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(s, v.Type().Bits())
+			if err != nil || v.OverflowFloat(f) {
+				d.saveError(&json.UnmarshalTypeError{Value: "number " + s, Type: v.Type()})
+				return nil
+			}
+			v.SetFloat(f)
+		default:
+			if v.Kind() == reflect.String && v.Type() == numberType {
+				v.SetString(s)
+				return nil
+			}
+			if fromQuoted {
+				return fmt.Errorf("json: invalid use of ,string struct tag, trying to unmarshal %q into %v", n.raw, v.Type())
+			}
+			d.saveError(&json.UnmarshalTypeError{Value: "number", Type: v.Type()})
+		}
+	}
+	return nil
+}
+
+// nonFinite stores Infinity or NaN in floats and empty interfaces. Everything
+// else is treated as if the value was null.
+func (d *decoder) nonFinite(f float64, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(f)
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(f))
+		}
+	}
+	return nil
+}
+
+// literalName names the kind of a scalar node in type errors
+func literalName(n *node) string {
+	switch {
+	case n.kind == kindNumber:
+		return "number"
+	case n.value == nil:
+		return "null"
+	default:
+		return "bool"
+	}
+}
+
+// objectInterface decodes an object into a map[string]interface{}
+func (d *decoder) objectInterface(n *node) map[string]interface{} {
+	m := make(map[string]interface{}, len(n.members))
+	for _, member := range n.members {
+		d.path = append(d.path, member.key)
+		m[member.key] = d.valueInterface(member.value)
+		d.path = d.path[:len(d.path)-1]
+	}
+	return m
+}
+
+// arrayInterface decodes an array into a []interface{}
+func (d *decoder) arrayInterface(n *node) []interface{} {
+	v := make([]interface{}, 0, len(n.members))
+	for i, m := range n.members {
+		d.path = append(d.path, strconv.Itoa(i))
+		v = append(v, d.valueInterface(m.value))
+		d.path = d.path[:len(d.path)-1]
+	}
+	return v
+}
+
+// valueInterface decodes any value like json.Unmarshal into an empty interface
+func (d *decoder) valueInterface(n *node) interface{} {
+	switch n.kind {
+	case kindObject:
+		return d.objectInterface(n)
+	case kindArray:
+		return d.arrayInterface(n)
+	case kindNumber:
+		s, ok := canonicalNumber(n.raw)
+		if !ok {
+			return n.value
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			d.node = n
+			d.saveError(&json.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeFor[float64]()})
+		}
+		return f
+	default:
+		return n.value
+	}
+}
+
+// isValidNumber reports whether s is a JSON number literal
+func isValidNumber(s string) bool {
+	p := &parser{data: []byte(s)}
+	if s == "" || s[0] != '-' && !isDigit(s[0]) {
+		return false
+	}
+	_, _, err := p.parseNumber()
+	return err == nil && p.pos == len(p.data)
+}
@@ -0,0 +1,61 @@
+package jsonedit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const decodeManifest = `{
+  "name": "web",
+  "version": "1.4.2",
+  "private": true,
+  "scripts": {"build": "vite build", "test": "vitest", "lint": "eslint ."},
+  "dependencies": {"axios": "^1.6.0", "react": "^18.2.0", "react-dom": "^18.2.0", "zod": "^3.22.0"},
+  "contributors": [
+    {"name": "alice", "email": "alice@example.com", "url": "https://alice.dev"},
+    {"name": "bob", "email": "bob@example.com"}
+  ],
+  "engines": {"node": ">=18"}
+}
+`
+
+type decodeTarget struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	Private      bool              `json:"private,omitempty"`
+	Scripts      map[string]string `json:"scripts"`
+	Dependencies map[string]string `json:"dependencies"`
+	Contributors []struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"contributors"`
+}
+
+// BenchmarkDecode compares decoding typed data from the parsed tree with
+// unmarshaling the input a second time, which Parse did before
+func BenchmarkDecode(b *testing.B) {
+	data := []byte(decodeManifest)
+	tree, _, err := parseTree(data, options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("tree", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var v decodeTarget
+			if err := decodeTree(tree, &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json.Unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var v decodeTarget
+			if err := json.Unmarshal(data, &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package jsonedit
+
+import "strconv"
+
+// Diff returns the JSON Patch (RFC 6902) that turns the document as it was
+// parsed into the document Write produces. Numbers are compared by value and
+// object members regardless of their order.
+func (d *Document[T]) Diff() ([]PatchOperation, error) {
+	after, err := d.generic()
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []PatchOperation
+	diffValues(&ops, nil, d.Original, after)
+	return ops, nil
+}
+
+// diffValues appends the operations turning a into b at the place of tokens
+func diffValues(ops *[]PatchOperation, tokens []string, a, b interface{}) {
+	switch x := a.(type) {
+	case *OrderedMap:
+		if y, ok := b.(*OrderedMap); ok {
+			diffObjects(ops, tokens, x, y)
+			return
+		}
+	case []interface{}:
+		if y, ok := b.([]interface{}); ok {
+			diffArrays(ops, tokens, x, y)
+			return
+		}
+	}
+	if !equalValues(a, true, b, true) {
+		*ops = append(*ops, PatchOperation{Op: "replace", Path: formatPointer(tokens), Value: b})
+	}
+}
+
+func diffObjects(ops *[]PatchOperation, tokens []string, a, b *OrderedMap) {
+	for _, key := range a.Keys {
+		path := append(tokens[:len(tokens):len(tokens)], key)
+		if bv, ok := b.Get(key); ok {
+			diffValues(ops, path, a.Values[key].Value, bv)
+		} else {
+			*ops = append(*ops, PatchOperation{Op: "remove", Path: formatPointer(path)})
+		}
+	}
+	for _, key := range b.Keys {
+		if _, ok := a.Values[key]; !ok {
+			path := append(tokens[:len(tokens):len(tokens)], key)
+			*ops = append(*ops, PatchOperation{Op: "add", Path: formatPointer(path), Value: b.Values[key].Value})
+		}
+	}
+}
+
+// diffArrays skips the elements both arrays start and end with and pairs the
+// ones in between by position. Elements left over are removed or inserted.
+func diffArrays(ops *[]PatchOperation, tokens []string, a, b []interface{}) {
+	start := 0
+	for start < len(a) && start < len(b) && equalValues(a[start], true, b[start], true) {
+		start++
+	}
+	end := 0
+	for end < len(a)-start && end < len(b)-start && equalValues(a[len(a)-1-end], true, b[len(b)-1-end], true) {
+		end++
+	}
+	a, b = a[start:len(a)-end], b[start:len(b)-end]
+
+	index := func(i int) []string {
+		return append(tokens[:len(tokens):len(tokens)], strconv.Itoa(start+i))
+	}
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		diffValues(ops, index(i), a[i], b[i])
+	}
+	// Remove from the back so that the indices stay valid
+	for i := len(a) - 1; i >= n; i-- {
+		*ops = append(*ops, PatchOperation{Op: "remove", Path: formatPointer(index(i))})
+	}
+	for i := n; i < len(b); i++ {
+		*ops = append(*ops, PatchOperation{Op: "add", Path: formatPointer(index(i)), Value: b[i]})
+	}
+}
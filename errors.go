@@ -0,0 +1,49 @@
+package jsonedit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// SyntaxError describes invalid input. Line and Column are 1-based, Column
+// counts bytes. Path is the JSON Pointer of the value that was being parsed.
+type SyntaxError struct {
+	Msg    string
+	Offset int
+	Line   int
+	Column int
+	Path   string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", e.Msg, e.Line, e.Column)
+}
+
+// TypeError describes a JSON value that cannot be decoded into the Go value
+// at its place in the typed data. Offset, Line and Column locate the start of
+// the value, Path is its JSON Pointer. Values stored by Set and the patch
+// methods have no place in the input, Line is 0 for them. It wraps the
+// *json.UnmarshalTypeError json.Unmarshal reports for the same input.
+type TypeError struct {
+	Value  string
+	Type   reflect.Type
+	Offset int
+	Line   int
+	Column int
+	Path   string
+
+	err *json.UnmarshalTypeError
+}
+
+func (e *TypeError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("jsonedit: cannot decode %s into Go value of type %s at %q", e.Value, e.Type, e.Path)
+	}
+	return fmt.Sprintf("jsonedit: cannot decode %s into Go value of type %s at %q, line %d, column %d",
+		e.Value, e.Type, e.Path, e.Line, e.Column)
+}
+
+func (e *TypeError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,331 @@
+package jsonedit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// field is a struct field as seen by encoding/json
+type field struct {
+	name      string
+	tag       bool
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+	omitZero  bool
+	// quoted is set by the string option for scalar fields, whose values are
+	// then encoded inside a JSON string
+	quoted bool
+}
+
+// structFields holds the fields of a struct type in field order together with
+// lookups by name
+type structFields struct {
+	list   []field
+	byName map[string]int
+	// byFold maps folded names to the first field with that name, used for
+	// case-insensitive matching
+	byFold map[string]int
+}
+
+// lookup returns the field a JSON object key is decoded into. Like
+// encoding/json an exact match is preferred over a case-insensitive one.
+func (sf *structFields) lookup(key string) *field {
+	if i, ok := sf.byName[key]; ok {
+		return &sf.list[i]
+	}
+	if i, ok := sf.byFold[foldName(key)]; ok {
+		return &sf.list[i]
+	}
+	return nil
+}
+
+var fieldCache sync.Map // map[reflect.Type]structFields
+
+// cachedTypeFields is like typeFields but resolves every type only once
+func cachedTypeFields(t reflect.Type) structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(structFields)
+}
+
+// typeFields returns the fields encoding/json uses for the struct type t.
+// Fields of embedded structs are promoted following the same rules: the
+// shallowest field wins, a tagged field wins over an untagged one at the same
+// depth and remaining conflicts drop the name entirely.
+func typeFields(t reflect.Type) structFields {
+	list := typeFieldList(t)
+	sf := structFields{
+		list:   list,
+		byName: make(map[string]int, len(list)),
+		byFold: make(map[string]int, len(list)),
+	}
+	for i, f := range list {
+		sf.byName[f.name] = i
+		if _, ok := sf.byFold[foldName(f.name)]; !ok {
+			sf.byFold[foldName(f.name)] = i
+		}
+	}
+	return sf
+}
+
+// typeFieldList returns the dominant fields of t in field order
+func typeFieldList(t reflect.Type) []field {
+	current := []field{}
+	next := []field{{typ: t}}
+
+	// Types of embedded structs at the current and next depth
+	count, nextCount := map[reflect.Type]int{}, map[reflect.Type]int{}
+	visited := map[reflect.Type]bool{}
+
+	var fields []field
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				if sf.Anonymous {
+					t := sf.Type
+					if t.Kind() == reflect.Pointer {
+						t = t.Elem()
+					}
+					if !sf.IsExported() && t.Kind() != reflect.Struct {
+						// Embedded fields of unexported non-struct types
+						continue
+					}
+					// Unexported embedded structs still promote their
+					// exported fields
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				if !isValidTag(name) {
+					name = ""
+				}
+
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+
+				// Named fields and untagged embedded non-structs are leaves
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+					quoted := false
+					if hasOption(opts, "string") {
+						switch ft.Kind() {
+						case reflect.Bool,
+							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+							reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+							reflect.Float32, reflect.Float64,
+							reflect.String:
+							quoted = true
+						}
+					}
+					fields = append(fields, field{
+						name:      name,
+						tag:       tagged,
+						index:     index,
+						typ:       sf.Type,
+						omitEmpty: hasOption(opts, "omitempty"),
+						omitZero:  hasOption(opts, "omitzero"),
+						quoted:    quoted,
+					})
+					if count[f.typ] > 1 {
+						// The same struct is embedded more than once at this
+						// depth, add a duplicate so the field is annihilated
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+
+				// Record the embedded struct to be explored at the next depth
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, field{name: ft.Name(), index: index, typ: ft})
+				}
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if len(a.index) != len(b.index) {
+			return len(a.index) < len(b.index)
+		}
+		if a.tag != b.tag {
+			return a.tag
+		}
+		return lessIndex(a.index, b.index)
+	})
+
+	// Keep the dominant field of every name
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		name := fields[i].name
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != name {
+				break
+			}
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+
+	fields = out
+	sort.Slice(fields, func(i, j int) bool {
+		return lessIndex(fields[i].index, fields[j].index)
+	})
+	return fields
+}
+
+// dominantField returns the field that wins among fields sharing a name. The
+// fields are sorted by depth and tagging, so only the first two need to be
+// compared.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tag == fields[1].tag {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+func lessIndex(a, b []int) bool {
+	for k, x := range a {
+		if k >= len(b) {
+			return false
+		}
+		if x != b[k] {
+			return x < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// value returns the field of the struct v. It reports false if an embedded
+// pointer on the way is nil.
+func (f *field) value(v reflect.Value) (reflect.Value, bool) {
+	for i, x := range f.index {
+		if i > 0 {
+			if v.Kind() == reflect.Pointer {
+				if v.IsNil() {
+					return reflect.Value{}, false
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// omit reports whether the field value v is left out by omitempty or omitzero
+func (f *field) omit(v reflect.Value) bool {
+	return f.omitEmpty && isEmptyValue(v) || f.omitZero && isZero(v)
+}
+
+// encoded returns the field value v as it is written. Values of quoted
+// fields become strings holding their JSON encoding.
+func (f *field) encoded(v reflect.Value) interface{} {
+	if !f.quoted || v.Kind() == reflect.Pointer && v.IsNil() {
+		return v.Interface()
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return v.Interface()
+	}
+	return string(data)
+}
+
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeFor[isZeroer]()
+
+// isZero reports whether v is zero for omitzero, preferring an IsZero method
+func isZero(v reflect.Value) bool {
+	switch {
+	case v.Type().Implements(isZeroerType):
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+			return true
+		}
+		return v.Interface().(isZeroer).IsZero()
+	case reflect.PointerTo(v.Type()).Implements(isZeroerType):
+		if !v.CanAddr() {
+			// Copy the value so the pointer method can be called
+			c := reflect.New(v.Type()).Elem()
+			c.Set(v)
+			v = c
+		}
+		return v.Addr().Interface().(isZeroer).IsZero()
+	}
+	return v.IsZero()
+}
+
+// foldName returns a case-insensitive key for name matching the folding
+// encoding/json applies to object keys
+func foldName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		b.WriteRune(unicode.ToUpper(unicode.ToLower(r)))
+	}
+	return b.String()
+}
+
+// hasOption reports whether the comma separated tag options contain opt
+func hasOption(opts, opt string) bool {
+	for opts != "" {
+		var name string
+		name, opts, _ = strings.Cut(opts, ",")
+		if name == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidTag reports whether encoding/json accepts name as a key from a tag
+func isValidTag(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+			// Backslash and quote chars are reserved, but otherwise any
+			// punctuation chars are allowed in a tag name.
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
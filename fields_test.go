@@ -0,0 +1,57 @@
+package jsonedit
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// BenchmarkTypeFields compares looking up the fields of a struct type in the
+// cache with resolving them on every call
+func BenchmarkTypeFields(b *testing.B) {
+	t := reflect.TypeFor[decodeTarget]()
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cachedTypeFields(t)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			typeFields(t)
+		}
+	})
+}
+
+// BenchmarkWriteTypeCache writes a document with filled caches and with the
+// caches cleared before every write, which resolves the types again like
+// Write did before they were cached
+func BenchmarkWriteTypeCache(b *testing.B) {
+	doc, err := Parse(strings.NewReader(decodeManifest), &decodeTarget{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	doc.TypedData.Dependencies["lodash"] = "^4.17.21"
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := doc.Write(io.Discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fieldCache.Clear()
+			marshalCache.Clear()
+			if err := doc.Write(io.Discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,341 @@
+package jsonedit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// json5Space reports whether r is whitespace in JSON5 beyond the JSON set
+func json5Space(r rune) bool {
+	switch r {
+	case '\v', '\f', '\u00a0', '\ufeff', '\u2028', '\u2029':
+		return true
+	}
+	return unicode.Is(unicode.Zs, r)
+}
+
+func isIdentifierStart(r rune) bool {
+	return r == '$' || r == '_' || unicode.IsLetter(r) || unicode.Is(unicode.Nl, r)
+}
+
+func isIdentifierPart(r rune) bool {
+	return isIdentifierStart(r) || unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc) ||
+		r == '\u200c' || r == '\u200d'
+}
+
+// isIdentifier reports whether key can be written as an unquoted JSON5 key
+func isIdentifier(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		if i == 0 && !isIdentifierStart(r) || !isIdentifierPart(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseIdentifier parses an unquoted object key
+func (p *parser) parseIdentifier() (string, string, error) {
+	start := p.pos
+	var b strings.Builder
+
+	for p.pos < len(p.data) {
+		r, size := utf8.DecodeRune(p.data[p.pos:])
+		if r == '\\' {
+			if p.pos+6 > len(p.data) || p.data[p.pos+1] != 'u' || !allHex(p.data[p.pos+2:p.pos+6]) {
+				p.pos++
+				return "", "", p.unexpected("in \\u escape of identifier")
+			}
+			r = hexRune(string(p.data[p.pos+2 : p.pos+6]))
+			size = 6
+		}
+		if p.pos == start && !isIdentifierStart(r) || !isIdentifierPart(r) {
+			break
+		}
+		b.WriteRune(r)
+		p.pos += size
+	}
+
+	if p.pos == start {
+		return "", "", p.unexpected("looking for beginning of object key")
+	}
+	return b.String(), string(p.data[start:p.pos]), nil
+}
+
+func allHex(b []byte) bool {
+	for _, c := range b {
+		if !isHex(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseString5 parses a single- or double-quoted JSON5 string and returns the
+// decoded and raw text
+func (p *parser) parseString5() (string, string, error) {
+	start := p.pos
+	quote := p.data[p.pos]
+	p.pos++
+	var b strings.Builder
+
+	for {
+		if p.pos >= len(p.data) {
+			return "", "", p.errorf("unexpected end of JSON input")
+		}
+		c := p.data[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), string(p.data[start:p.pos]), nil
+		case c == '\n' || c == '\r':
+			return "", "", p.unexpected("in string literal")
+		case c == '\\':
+			p.pos++
+			if err := p.escape5(&b); err != nil {
+				return "", "", err
+			}
+		case c < utf8.RuneSelf:
+			b.WriteByte(c)
+			p.pos++
+		default:
+			r, size := utf8.DecodeRune(p.data[p.pos:])
+			b.WriteRune(r)
+			p.pos += size
+		}
+	}
+}
+
+// escape5 decodes the escape sequence following a backslash
+func (p *parser) escape5(b *strings.Builder) error {
+	if p.pos >= len(p.data) {
+		return p.errorf("unexpected end of JSON input")
+	}
+
+	c := p.data[p.pos]
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'v':
+		b.WriteByte('\v')
+	case '0':
+		if isDigit(p.peekAt(1)) {
+			return p.unexpected("in string escape code")
+		}
+		b.WriteByte(0)
+	case 'x':
+		if p.pos+3 > len(p.data) || !allHex(p.data[p.pos+1:p.pos+3]) {
+			return p.unexpected("in \\x hexadecimal character escape")
+		}
+		b.WriteRune(hexRune(string(p.data[p.pos+1 : p.pos+3])))
+		p.pos += 2
+	case 'u':
+		if p.pos+5 > len(p.data) || !allHex(p.data[p.pos+1:p.pos+5]) {
+			return p.unexpected("in \\u hexadecimal character escape")
+		}
+		r := hexRune(string(p.data[p.pos+1 : p.pos+5]))
+		p.pos += 4
+		if r >= 0xd800 && r < 0xdc00 && p.pos+7 <= len(p.data) &&
+			p.data[p.pos+1] == '\\' && p.data[p.pos+2] == 'u' && allHex(p.data[p.pos+3:p.pos+7]) {
+			if r2 := hexRune(string(p.data[p.pos+3 : p.pos+7])); r2 >= 0xdc00 && r2 < 0xe000 {
+				r = (r-0xd800)<<10 + (r2 - 0xdc00) + 0x10000
+				p.pos += 6
+			}
+		}
+		if r >= 0xd800 && r < 0xe000 {
+			r = utf8.RuneError
+		}
+		b.WriteRune(r)
+	case '\n':
+		// Line continuation
+	case '\r':
+		if p.peekAt(1) == '\n' {
+			p.pos++
+		}
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return p.unexpected("in string escape code")
+	default:
+		r, size := utf8.DecodeRune(p.data[p.pos:])
+		p.pos += size
+		// U+2028 and U+2029 continue the line like \n
+		if r != '\u2028' && r != '\u2029' {
+			b.WriteRune(r)
+		}
+		return nil
+	}
+	p.pos++
+	return nil
+}
+
+// peekAt returns the byte at offset i from the current position or 0
+func (p *parser) peekAt(i int) byte {
+	if p.pos+i < len(p.data) {
+		return p.data[p.pos+i]
+	}
+	return 0
+}
+
+// parseNumber5 parses a JSON5 number literal including hexadecimal numbers,
+// Infinity and NaN
+func (p *parser) parseNumber5() (*node, interface{}, error) {
+	start := p.pos
+
+	if c := p.peek(); c == '-' || c == '+' {
+		p.pos++
+	}
+
+	switch c := p.peek(); {
+	case c == 'I':
+		if err := p.expectWord("Infinity"); err != nil {
+			return nil, nil, err
+		}
+	case c == 'N':
+		if err := p.expectWord("NaN"); err != nil {
+			return nil, nil, err
+		}
+	case c == '0' && (p.peekAt(1) == 'x' || p.peekAt(1) == 'X'):
+		p.pos += 2
+		if !isHex(p.peek()) {
+			return nil, nil, p.unexpected("in hexadecimal numeric literal")
+		}
+		for isHex(p.peek()) {
+			p.pos++
+		}
+	case isDigit(c) || c == '.':
+		if c == '0' {
+			p.pos++
+		} else {
+			p.digits()
+		}
+		intDigits := p.pos > start && isDigit(p.data[p.pos-1])
+		if p.peek() == '.' {
+			p.pos++
+			if !intDigits && !isDigit(p.peek()) {
+				return nil, nil, p.unexpected("after decimal point in numeric literal")
+			}
+			p.digits()
+		}
+		if c := p.peek(); c == 'e' || c == 'E' {
+			p.pos++
+			if c := p.peek(); c == '+' || c == '-' {
+				p.pos++
+			}
+			if !isDigit(p.peek()) {
+				return nil, nil, p.unexpected("in exponent of numeric literal")
+			}
+			p.digits()
+		}
+	default:
+		return nil, nil, p.unexpected("in numeric literal")
+	}
+
+	raw := string(p.data[start:p.pos])
+	value := number5Value(raw)
+	return &node{kind: kindNumber, raw: raw, value: value}, value, nil
+}
+
+func (p *parser) expectWord(word string) error {
+	for i := 0; i < len(word); i++ {
+		if p.peek() != word[i] {
+			return p.unexpected("in literal " + word)
+		}
+		p.pos++
+	}
+	return nil
+}
+
+// number5Value converts a JSON5 number literal to the value stored in the
+// ordered tree. Infinity and NaN are stored as float64 as json.Number cannot
+// hold them.
+func number5Value(raw string) interface{} {
+	if lit, ok := canonicalNumber(raw); ok {
+		return numberValue(lit)
+	}
+	f, _ := strconv.ParseFloat(raw, 64)
+	return f
+}
+
+// canonicalNumber converts a JSON5 number literal to a JSON number literal.
+// It reports false for Infinity and NaN, which JSON cannot represent.
+func canonicalNumber(raw string) (string, bool) {
+	if json.Valid([]byte(raw)) {
+		return raw, true
+	}
+
+	s, neg := raw, false
+	switch s[0] {
+	case '-':
+		s, neg = s[1:], true
+	case '+':
+		s = s[1:]
+	}
+	sign := ""
+	if neg {
+		sign = "-"
+	}
+
+	switch {
+	case s == "Infinity" || s == "NaN":
+		return "", false
+	case len(s) > 1 && (s[1] == 'x' || s[1] == 'X'):
+		i, _ := new(big.Int).SetString(s[2:], 16)
+		if i.Sign() == 0 {
+			return "0", true
+		}
+		return sign + i.String(), true
+	}
+
+	mantissa, exponent := s, ""
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mantissa, exponent = s[:i], s[i:]
+	}
+	if strings.HasPrefix(mantissa, ".") {
+		mantissa = "0" + mantissa
+	}
+	mantissa = strings.TrimSuffix(mantissa, ".")
+	return sign + mantissa + exponent, true
+}
+
+// number returns the literal used to compare the number with typed values
+func (n *node) number() string {
+	if lit, ok := canonicalNumber(n.raw); ok {
+		return lit
+	}
+	if f, ok := n.value.(float64); ok && math.IsNaN(f) {
+		return "NaN"
+	}
+	return strings.TrimPrefix(n.raw, "+")
+}
+
+// quoteStyle returns the quote character of a string or key token
+func quoteStyle(raw string) byte {
+	if raw != "" && raw[0] == '\'' {
+		return '\''
+	}
+	return '"'
+}
+
+// appendString appends s as a strict JSON string
+func appendString(buf []byte, s string) []byte {
+	data, err := json.Marshal(s)
+	if err != nil {
+		panic(fmt.Sprintf("jsonedit: marshal string: %v", err))
+	}
+	return append(buf, data...)
+}
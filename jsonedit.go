@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"sort"
 	"strings"
 	"unicode"
+	"unicode/utf16"
 )
 
 // Format represents detected JSON formatting
@@ -18,9 +21,51 @@ type Format struct {
 	SpaceAfterColon bool
 	SpaceAfterComma bool
 	TrailingNewline bool
+	// CRLF writes line breaks as \r\n
+	CRLF bool
+
+	// UnquotedKeys writes new keys without quotes where JSON5 allows it
+	UnquotedKeys bool
+	// SingleQuotes writes new strings and quoted keys with single quotes (JSON5)
+	SingleQuotes bool
+	// Escape selects how new and modified strings are escaped. Untouched
+	// strings keep the escapes they had in the source.
+	Escape EscapePolicy
 }
 
-// OrderedValue preserves the order and type of JSON values
+// EscapePolicy controls which characters are escaped in written strings
+type EscapePolicy int
+
+const (
+	// EscapeMinimal only escapes quotes, backslashes and control characters
+	EscapeMinimal EscapePolicy = iota
+	// EscapeHTML additionally escapes <, > and & as well as U+2028 and
+	// U+2029 like encoding/json does
+	EscapeHTML
+	// EscapeASCII additionally escapes every non-ASCII character as \uXXXX
+	EscapeASCII
+)
+
+// layout returns f without the settings that only affect how individual
+// tokens are written. Changing any of the remaining fields re-renders the
+// whole document.
+func (f Format) layout() Format {
+	f.UnquotedKeys = false
+	f.SingleQuotes = false
+	f.Escape = EscapeMinimal
+	return f
+}
+
+// newline returns the line break to write
+func (f Format) newline() string {
+	if f.CRLF {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// OrderedValue preserves the order and type of JSON values. Numbers are
+// stored as json.Number holding the literal from the source.
 type OrderedValue struct {
 	Order int
 	Value interface{}
@@ -30,6 +75,8 @@ type OrderedValue struct {
 type OrderedMap struct {
 	Keys   []string
 	Values map[string]*OrderedValue
+
+	node *node
 }
 
 // NewOrderedMap creates a new OrderedMap
@@ -56,12 +103,58 @@ func (om *OrderedMap) Get(key string) (interface{}, bool) {
 	return nil, false
 }
 
-// Document represents a parsed JSON document with formatting preserved
+// Delete removes key
+func (om *OrderedMap) Delete(key string) {
+	if _, exists := om.Values[key]; !exists {
+		return
+	}
+	delete(om.Values, key)
+	for i, k := range om.Keys {
+		if k == key {
+			om.Keys = append(om.Keys[:i:i], om.Keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Comments returns the comments attached to key. Leading comments are the
+// ones in front of the key, trailing comments follow its value on the same
+// line. Comments are only present when parsing a dialect that allows them.
+func (om *OrderedMap) Comments(key string) (leading, trailing []string) {
+	m, _ := om.node.lookup(key)
+	if m == nil {
+		return nil, nil
+	}
+	leading = comments(m.lead)
+	trailing = append(comments(m.preColon), comments(m.postColon)...)
+	trailing = append(trailing, comments(m.preComma)...)
+	trailing = append(trailing, comments(m.trail)...)
+	return leading, trailing
+}
+
+// Document represents a parsed JSON document with formatting preserved.
+// The root may be any JSON value. Original holds the parsed root, for object
+// roots it is the same map as OriginalMap. Rest is only used for object roots,
+// without typed data it is the same map as OriginalMap too. Set, Delete and
+// the patch methods leave Original unchanged: the first of them copies the
+// original values and Rest, and later edits of OriginalMap are not written.
 type Document[T interface{}] struct {
 	TypedData   T
 	Rest        *OrderedMap
 	Format      Format
 	OriginalMap *OrderedMap
+	Original    interface{}
+	// Placement decides where keys added to typed maps are inserted
+	Placement Placement
+	// IdentityKey matches elements of typed arrays with the original ones by
+	// the value of this key instead of by index
+	IdentityKey string
+
+	tree *syntaxTree
+	// edited is the copy of Original edits below typed data go to, it is
+	// made on the first edit
+	edited   interface{}
+	isEdited bool
 }
 
 // String serializes the document to a JSON string
@@ -75,19 +168,77 @@ func (d *Document[T]) String() (string, error) {
 
 // Write serializes the document to an io.Writer
 func (d *Document[T]) Write(w io.Writer) error {
+	// Write errors are recorded by ew and returned at the end, the encoder
+	// does not need to check them
+	ew := &errWriter{w: w}
 	merged := d.mergeInOriginalOrder()
-	encoder := d.createEncoder(w)
-	if err := encoder.encode(merged, 0); err != nil {
-		return err
+	encoder := d.createEncoder(ew)
+
+	if d.tree == nil {
+		if err := encoder.encode(merged, nil, ""); err != nil {
+			return err
+		}
+
+		// Add trailing newline if present in original
+		if d.Format.TrailingNewline {
+			io.WriteString(ew, d.Format.newline())
+		}
+		return ew.err
+	}
+
+	// As long as Format is untouched, only changed values are re-rendered and
+	// everything else is copied from the source. Once Format was modified the
+	// layout is rendered from it and only comments are carried over.
+	lead, tail := d.tree.lead, d.tree.tail
+	if encoder.reformat {
+		lead, tail = "", ""
+		for _, c := range comments(d.tree.lead) {
+			lead += c + d.Format.newline()
+		}
+		for _, c := range comments(d.tree.tail) {
+			tail += d.Format.newline() + c
+		}
+		if d.Format.TrailingNewline {
+			tail += d.Format.newline()
+		}
 	}
 
-	// Add trailing newline if present in original
-	if d.Format.TrailingNewline {
-		_, err := w.Write([]byte("\n"))
+	io.WriteString(ew, lead)
+	if err := encoder.encode(merged, d.tree.root, lineIndent(lead, "")); err != nil {
 		return err
 	}
+	io.WriteString(ew, tail)
+	return ew.err
+}
+
+// errWriter keeps the first error of w and drops all writes after it
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	ew.err = err
+	return n, err
+}
 
-	return nil
+func (ew *errWriter) WriteString(s string) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := io.WriteString(ew.w, s)
+	if err == nil && n < len(s) {
+		err = io.ErrShortWrite
+	}
+	ew.err = err
+	return n, err
 }
 
 func isNil[T any](x T) bool {
@@ -105,156 +256,150 @@ func isNil[T any](x T) bool {
 	}
 }
 
-// mergeInOriginalOrder merges typed and rest data in the original order
-func (d *Document[T]) mergeInOriginalOrder() interface{} {
-	if d.OriginalMap == nil {
-		return nil
-	}
-
-	result := NewOrderedMap()
-
-	// Get typed fields mapping and order
-	typedFields := make(map[string]interface{})
-	typedOrder := []string{}
-	if !isNil(d.TypedData) {
-		v := reflect.ValueOf(d.TypedData)
-		if v.Kind() == reflect.Pointer {
-			v = v.Elem()
-		}
-		t := v.Type()
-
-		if v.Kind() == reflect.Struct {
-			for i := 0; i < v.NumField(); i++ {
-				field := t.Field(i)
-				fieldValue := v.Field(i)
+// isBound reports whether typed data takes part in decoding and encoding.
+// Nil pointers and interfaces opt out, value types such as slices are always
+// bound.
+func isBound[T any](x T) bool {
+	return isValueType[T]() || !isNil(x)
+}
 
-				jsonTag := field.Tag.Get("json")
-				if jsonTag == "-" {
-					continue
-				}
+// isValueType reports whether T is neither a pointer nor an interface type
+func isValueType[T any]() bool {
+	k := reflect.TypeFor[T]().Kind()
+	return k != reflect.Interface && k != reflect.Pointer
+}
 
-				name := field.Name
-				if jsonTag != "" {
-					parts := strings.Split(jsonTag, ",")
-					if parts[0] != "" {
-						name = parts[0]
-					}
-
-					if strings.Contains(jsonTag, "omitempty") &&
-						isEmptyValue(fieldValue) {
-						continue
-					}
-				}
+// current returns the original values with the edits made below typed data
+func (d *Document[T]) current() interface{} {
+	if d.isEdited {
+		return d.edited
+	}
+	return d.Original
+}
 
-				typedFields[name] = fieldValue.Interface()
-				typedOrder = append(typedOrder, name)
-			}
+// editable returns the original values to edit, copying them and Rest, which
+// shares values with them, on first use
+func (d *Document[T]) editable() *interface{} {
+	if !d.isEdited {
+		d.edited, d.isEdited = cloneValue(d.Original), true
+		if d.Rest != nil {
+			d.Rest = cloneValue(d.Rest).(*OrderedMap)
 		}
 	}
+	return &d.edited
+}
 
-	// Iterate through original order
-	typedIndex := 0
-	for _, key := range d.OriginalMap.Keys {
-		// Ensure any preceding typed keys that were missing in the original
-		// document are emitted before the current typed key.
-		if _, isTypedKey := typedFields[key]; isTypedKey {
-			for typedIndex < len(typedOrder) && typedOrder[typedIndex] != key {
-				missingKey := typedOrder[typedIndex]
-				if _, alreadySet := result.Values[missingKey]; !alreadySet {
-					if missingVal, ok := typedFields[missingKey]; ok {
-						result.Set(missingKey, missingVal, len(result.Keys))
-					}
-				}
-				typedIndex++
-			}
+// cloneValue deep copies a tree of *OrderedMap, []interface{} and scalars
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *OrderedMap:
+		om := &OrderedMap{
+			Keys:   append([]string{}, v.Keys...),
+			Values: make(map[string]*OrderedValue, len(v.Values)),
+			node:   v.node,
 		}
-
-		if typedVal, ok := typedFields[key]; ok {
-			// Check if the original value was an OrderedMap (nested object)
-			// and the typed value is a map
-			if origVal, origOk := d.OriginalMap.Get(key); origOk {
-				if origMap, isOrderedMap := origVal.(*OrderedMap); isOrderedMap {
-					if typedMap, isMap := typedVal.(map[string]string); isMap {
-						// Merge typed map into ordered map preserving order
-						mergedMap := NewOrderedMap()
-						// First add existing keys that are still in typed map (preserving order)
-						for _, origKey := range origMap.Keys {
-							if val, exists := typedMap[origKey]; exists {
-								mergedMap.Set(origKey, val, len(mergedMap.Keys))
-							}
-							// Don't add keys that were deleted from typed map
-						}
-						// Then add any new keys from typed map
-						for typedKey, typedValue := range typedMap {
-							if _, exists := mergedMap.Values[typedKey]; !exists {
-								mergedMap.Set(typedKey, typedValue, len(mergedMap.Keys))
-							}
-						}
-						result.Set(key, mergedMap, len(result.Keys))
-					} else {
-						// Not a string map, use typed value as-is
-						result.Set(key, typedVal, len(result.Keys))
-					}
-				} else {
-					// Original wasn't an ordered map, use typed value
-					result.Set(key, typedVal, len(result.Keys))
-				}
-			} else {
-				// No original value, use typed value
-				result.Set(key, typedVal, len(result.Keys))
-			}
-		} else if d.Rest != nil {
-			// Use rest value
-			if val, ok := d.Rest.Get(key); ok {
-				result.Set(key, val, len(result.Keys))
-			}
-		} else {
-			// Use original value if no typed or rest value
-			if val, ok := d.OriginalMap.Get(key); ok {
-				result.Set(key, val, len(result.Keys))
-			}
+		for key, ov := range v.Values {
+			om.Values[key] = &OrderedValue{Order: ov.Order, Value: cloneValue(ov.Value)}
 		}
+		return om
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, elem := range v {
+			arr[i] = cloneValue(elem)
+		}
+		return arr
+	}
+	return v
+}
 
-		if typedIndex < len(typedOrder) && typedOrder[typedIndex] == key {
-			typedIndex++
+// mergeInOriginalOrder merges typed and rest data in the original order
+func (d *Document[T]) mergeInOriginalOrder() interface{} {
+	original := d.current()
+	originalMap, _ := original.(*OrderedMap)
+	if originalMap == nil {
+		// Arrays and scalars at the root are written from the typed data if
+		// there is any
+		if isBound(d.TypedData) {
+			return d.merger().mergeValue(d.TypedData, original)
 		}
+		return original
 	}
 
-	// Append any remaining typed keys that were not present in the original
-	// document.
-	for typedIndex < len(typedOrder) {
-		key := typedOrder[typedIndex]
-		if _, alreadySet := result.Values[key]; !alreadySet {
-			if typedVal, ok := typedFields[key]; ok {
-				result.Set(key, typedVal, len(result.Keys))
-			}
+	var v reflect.Value
+	if !isNil(d.TypedData) {
+		v = reflect.ValueOf(d.TypedData)
+		if _, ok := marshalerOf(v); ok {
+			// Types encoding themselves replace the whole document
+			return d.TypedData
+		}
+		if m := reflect.Indirect(v); m.Kind() == reflect.Map && isMapKey(m.Type().Key()) {
+			// A map at the root holds all keys
+			return d.merger().mergeMap(m, originalMap)
 		}
-		typedIndex++
 	}
 
-	return result
+	// Use original values if there is no rest
+	rest := d.Rest
+	if rest == nil {
+		rest = originalMap
+	}
+	if !v.IsValid() {
+		// Without typed data rest holds the whole object in its order
+		return d.merger().mergeObject(v, rest, rest)
+	}
+
+	return d.merger().mergeObject(v, originalMap, rest)
+}
+
+func (d *Document[T]) merger() *merger {
+	return &merger{placement: d.Placement, identityKey: d.IdentityKey}
 }
 
 // customEncoder handles ordered serialization
 type customEncoder struct {
-	w      io.Writer
-	format Format
+	w        io.Writer
+	format   Format
+	reformat bool
+	json5    bool
+	// oneLine is set inside a source container written on a single line,
+	// containers added to it stay on that line
+	oneLine bool
 }
 
 func (d *Document[T]) createEncoder(w io.Writer) *customEncoder {
 	return &customEncoder{
-		w:      w,
-		format: d.Format,
+		w:        w,
+		format:   d.Format,
+		reformat: d.tree != nil && d.tree.format.layout() != d.Format.layout(),
+		json5:    d.tree != nil && d.tree.dialect == JSON5,
 	}
 }
 
-// encodeString writes a JSON string with minimal escaping (only escapes required characters)
+// encodeString writes a JSON string in the quoting style given by Format
 func (ce *customEncoder) encodeString(s string) error {
-	ce.w.Write([]byte(`"`))
+	if ce.format.SingleQuotes {
+		return ce.encodeQuoted(s, '\'')
+	}
+	return ce.encodeQuoted(s, '"')
+}
+
+// encodeKey writes a new object key, unquoted if Format asks for it and the
+// key is a valid identifier
+func (ce *customEncoder) encodeKey(key string) error {
+	if ce.format.UnquotedKeys && isIdentifier(key) {
+		_, err := io.WriteString(ce.w, key)
+		return err
+	}
+	return ce.encodeString(key)
+}
+
+// encodeQuoted writes a string escaped according to Format.Escape
+func (ce *customEncoder) encodeQuoted(s string, quote byte) error {
+	ce.w.Write([]byte{quote})
 	for _, r := range s {
 		switch r {
-		case '"':
-			ce.w.Write([]byte(`\"`))
+		case rune(quote):
+			ce.w.Write([]byte{'\\', quote})
 		case '\\':
 			ce.w.Write([]byte(`\\`))
 		case '\n':
@@ -267,41 +412,119 @@ func (ce *customEncoder) encodeString(s string) error {
 			ce.w.Write([]byte(`\b`))
 		case '\f':
 			ce.w.Write([]byte(`\f`))
+		case '<', '>', '&', '\u2028', '\u2029':
+			if ce.format.Escape == EscapeHTML {
+				fmt.Fprintf(ce.w, `\u%04x`, r)
+			} else {
+				ce.w.Write([]byte(string(r)))
+			}
 		default:
-			// Escape control characters (0x00-0x1F) as \uXXXX
-			if r < 0x20 {
+			switch {
+			case r < 0x20:
+				// Escape control characters (0x00-0x1F) as \uXXXX
 				fmt.Fprintf(ce.w, `\u%04X`, r)
-			} else {
+			case r > unicode.MaxASCII && ce.format.Escape == EscapeASCII:
+				if r1, r2 := utf16.EncodeRune(r); r1 != unicode.ReplacementChar {
+					fmt.Fprintf(ce.w, `\u%04x\u%04x`, r1, r2)
+				} else {
+					fmt.Fprintf(ce.w, `\u%04x`, r)
+				}
+			default:
 				// Write the character as-is (UTF-8 encoding)
 				ce.w.Write([]byte(string(r)))
 			}
 		}
 	}
-	ce.w.Write([]byte(`"`))
+	ce.w.Write([]byte{quote})
 	return nil
 }
 
-func (ce *customEncoder) encode(v interface{}, depth int) error {
+func (ce *customEncoder) encode(v interface{}, n *node, indent string) error {
 	switch val := v.(type) {
 	case *OrderedMap:
-		return ce.encodeOrderedMap(val, depth)
-	case map[string]string:
-		return ce.encodeMap(val, depth)
-	case map[string]interface{}:
-		return ce.encodeGenericMap(val, depth)
+		return ce.encodeOrderedMap(val, n, indent)
 	case []interface{}:
-		return ce.encodeArray(val, depth)
+		return ce.encodeArray(val, nil, n, indent)
+	case *mergedArray:
+		return ce.encodeArray(val.values, val.source, n, indent)
 	case string:
+		if n.matches(val) {
+			_, err := io.WriteString(ce.w, n.raw)
+			return err
+		}
+		// Changed strings keep their quoting style
+		if n != nil && n.kind == kindString {
+			return ce.encodeQuoted(val, quoteStyle(n.raw))
+		}
 		return ce.encodeString(val)
+	case json.Number:
+		if n.matches(val) {
+			_, err := io.WriteString(ce.w, n.raw)
+			return err
+		}
+		data, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		_, err = ce.w.Write(data)
+		return err
 	case float64, bool, nil:
-		data, _ := json.Marshal(val)
-		_, err := ce.w.Write(data)
+		if n.matches(val) {
+			_, err := io.WriteString(ce.w, n.raw)
+			return err
+		}
+		if f, ok := val.(float64); ok && ce.json5 {
+			switch {
+			case math.IsNaN(f):
+				_, err := io.WriteString(ce.w, "NaN")
+				return err
+			case math.IsInf(f, 1):
+				_, err := io.WriteString(ce.w, "Infinity")
+				return err
+			case math.IsInf(f, -1):
+				_, err := io.WriteString(ce.w, "-Infinity")
+				return err
+			}
+		}
+		data, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		_, err = ce.w.Write(data)
 		return err
 	default:
 		rv := reflect.ValueOf(val)
-		if rv.Kind() == reflect.Struct ||
-			(rv.Kind() == reflect.Pointer && rv.Elem().Kind() == reflect.Struct) {
-			return ce.encodeStruct(rv, depth)
+		if rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return ce.encode(nil, n, indent)
+		}
+		if m, ok := marshalerOf(rv); ok {
+			return ce.encodeMarshaler(rv, m, n, indent)
+		}
+		switch rv.Kind() {
+		case reflect.Pointer:
+			return ce.encode(rv.Elem().Interface(), n, indent)
+		case reflect.Struct:
+			return ce.encodeStruct(rv, n, indent)
+		case reflect.Map:
+			if rv.IsNil() {
+				return ce.encode(nil, n, indent)
+			}
+			if isMapKey(rv.Type().Key()) {
+				return ce.encodeMap(rv, n, indent)
+			}
+		case reflect.Slice:
+			if rv.IsNil() {
+				return ce.encode(nil, n, indent)
+			}
+			if rv.Type().Elem().Kind() != reflect.Uint8 {
+				return ce.encodeArray(sliceValues(rv), nil, n, indent)
+			}
+		case reflect.Array:
+			return ce.encodeArray(sliceValues(rv), nil, n, indent)
+		}
+		if n.matches(val) {
+			_, err := io.WriteString(ce.w, n.raw)
+			return err
 		}
 		data, err := json.Marshal(val)
 		if err != nil {
@@ -312,337 +535,487 @@ func (ce *customEncoder) encode(v interface{}, depth int) error {
 	}
 }
 
-func (ce *customEncoder) encodeOrderedMap(om *OrderedMap, depth int) error {
-	ce.w.Write([]byte("{"))
+// memberLead returns the trivia written in front of the i-th member of a
+// container. Members that existed in the original keep their own trivia,
+// new members copy the layout of their siblings or fall back to Format.
+// When the document is reformatted only the comments of a member are kept.
+func (ce *customEncoder) memberLead(n *node, m *member, pos, i int, indent string) string {
+	if ce.reformat {
+		if m == nil {
+			return ce.defaultLead(i, indent)
+		}
+		return ce.withComments(ce.defaultLead(i, indent), comments(m.lead))
+	}
 
-	for i, key := range om.Keys {
-		if i > 0 {
-			ce.w.Write([]byte(","))
+	if m != nil && ((pos == 0) == (i == 0) || len(comments(m.lead)) > 0) {
+		return m.lead
+	}
+	if n != nil {
+		switch {
+		case i == 0 && len(n.members) > 0:
+			return layout(n.members[0].lead)
+		case i > 0 && len(n.members) > 1:
+			return layout(n.members[1].lead)
+		case i > 0 && len(n.members) == 1 && strings.Contains(n.members[0].lead, "\n"):
+			return layout(n.members[0].lead)
+		case i > 0 && len(n.members) == 1 && !strings.Contains(n.closeLead, "\n"):
+			// A single member on one line, keep the container on one line
 			if ce.format.SpaceAfterComma {
-				ce.w.Write([]byte(" "))
+				return " "
 			}
+			return ""
 		}
+	}
+	return ce.defaultLead(i, indent)
+}
 
-		if !ce.format.Compact {
-			ce.w.Write([]byte("\n"))
-			ce.w.Write([]byte(strings.Repeat(ce.format.Indent, depth+1)))
-		}
+// enterLine sets oneLine if the source container n is written on a single
+// line and reports whether it did
+func (ce *customEncoder) enterLine(n *node) bool {
+	if ce.oneLine || ce.reformat || n == nil || len(n.members) == 0 || n.hasLineBreak() {
+		return false
+	}
+	ce.oneLine = true
+	return true
+}
 
-		// Write key
-		if err := ce.encodeString(key); err != nil {
-			return err
-		}
-		ce.w.Write([]byte(":"))
+// defaultLead returns the trivia in front of the i-th member according to Format
+func (ce *customEncoder) defaultLead(i int, indent string) string {
+	if !ce.format.Compact && !ce.oneLine {
+		return ce.format.newline() + indent + ce.format.Indent
+	}
+	if i > 0 && ce.format.SpaceAfterComma {
+		return " "
+	}
+	return ""
+}
 
-		if ce.format.SpaceAfterColon {
-			ce.w.Write([]byte(" "))
-		}
+// closeLead returns the trivia written in front of a closing bracket
+func (ce *customEncoder) closeLead(n *node, count int, indent string) string {
+	if n != nil && !ce.reformat && (len(n.members) > 0) == (count > 0) {
+		return n.closeLead
+	}
 
-		// Write value
-		if ov, ok := om.Values[key]; ok {
-			if err := ce.encode(ov.Value, depth+1); err != nil {
-				return err
+	lead := ""
+	if !ce.format.Compact && !ce.oneLine && count > 0 {
+		lead = ce.format.newline() + indent
+	}
+	if n != nil && ce.reformat {
+		if cs := comments(n.closeLead); len(cs) > 0 {
+			if ce.format.Compact {
+				return ce.withComments(lead, cs)
 			}
+			// Comments in front of the bracket are indented like the members
+			childLead := ce.format.newline() + indent + ce.format.Indent
+			return strings.TrimSuffix(ce.withComments(childLead, cs), childLead) + ce.format.newline() + indent
 		}
 	}
+	return lead
+}
 
-	if !ce.format.Compact && len(om.Keys) > 0 {
-		ce.w.Write([]byte("\n"))
-		ce.w.Write([]byte(strings.Repeat(ce.format.Indent, depth)))
+// inline returns trivia that appears inside a member, e.g. around the colon.
+// When the document is reformatted only its comments are kept.
+func (ce *customEncoder) inline(trivia, indent string) string {
+	if !ce.reformat {
+		return trivia
 	}
-
-	ce.w.Write([]byte("}"))
-	return nil
+	var b strings.Builder
+	for _, c := range comments(trivia) {
+		b.WriteString(" ")
+		b.WriteString(c)
+		if strings.HasPrefix(c, "//") {
+			b.WriteString(ce.format.newline() + indent)
+		}
+	}
+	return b.String()
 }
 
-func (ce *customEncoder) encodeMap(m map[string]string, depth int) error {
-	// Convert to OrderedMap to maintain order if possible
-	om := NewOrderedMap()
-	i := 0
-	for k, v := range m {
-		om.Set(k, v, i)
-		i++
+// trail returns the same-line comments written after a member
+func (ce *customEncoder) trail(m *member) string {
+	if m == nil {
+		return ""
+	}
+	if !ce.reformat {
+		return m.trail
+	}
+	cs := comments(m.trail)
+	if len(cs) == 0 {
+		return ""
 	}
-	return ce.encodeOrderedMap(om, depth)
+	return " " + strings.Join(cs, " ")
 }
 
-func (ce *customEncoder) encodeGenericMap(m map[string]interface{}, depth int) error {
-	om := NewOrderedMap()
-	i := 0
-	for k, v := range m {
-		om.Set(k, v, i)
-		i++
+// writeTrivia writes the trail of the previous member followed by the next
+// lead, making sure a line comment is never followed by anything on its line.
+func (ce *customEncoder) writeTrivia(trail, next string) {
+	io.WriteString(ce.w, trail)
+	if endsWithLineComment(trail) {
+		next = strings.TrimLeft(next, " \t")
+		if !strings.HasPrefix(next, "\n") && !strings.HasPrefix(next, "\r\n") {
+			io.WriteString(ce.w, ce.format.newline())
+		}
 	}
-	return ce.encodeOrderedMap(om, depth)
+	io.WriteString(ce.w, next)
 }
 
-func (ce *customEncoder) encodeArray(arr []interface{}, depth int) error {
-	ce.w.Write([]byte("["))
+// withComments places comments in front of the layout given by lead, one per
+// line if lead starts a new line.
+func (ce *customEncoder) withComments(lead string, cs []string) string {
+	if len(cs) == 0 {
+		return lead
+	}
 
-	for i, item := range arr {
-		if i > 0 {
-			ce.w.Write([]byte(","))
-			if ce.format.SpaceAfterComma {
-				ce.w.Write([]byte(" "))
-			}
+	var b strings.Builder
+	if i := strings.LastIndexByte(lead, '\n'); i >= 0 {
+		b.WriteString(lead[:i])
+		for _, c := range cs {
+			b.WriteString(lead[i:])
+			b.WriteString(c)
 		}
+		b.WriteString(lead[i:])
+		return b.String()
+	}
 
-		if !ce.format.Compact {
-			ce.w.Write([]byte("\n"))
-			ce.w.Write([]byte(strings.Repeat(ce.format.Indent, depth+1)))
+	b.WriteString(lead)
+	for _, c := range cs {
+		b.WriteString(c)
+		if strings.HasPrefix(c, "//") {
+			b.WriteString(ce.format.newline())
+		} else {
+			b.WriteString(" ")
 		}
+	}
+	return b.String()
+}
 
-		if err := ce.encode(item, depth+1); err != nil {
-			return err
+// layout strips the comments from the lead of a sibling so that its
+// whitespace can be reused for a new member.
+func layout(lead string) string {
+	if len(comments(lead)) == 0 {
+		return lead
+	}
+	if i := strings.IndexByte(lead, '\n'); i >= 0 {
+		if i > 0 && lead[i-1] == '\r' {
+			return "\r\n" + lineIndent(lead, "")
 		}
+		return "\n" + lineIndent(lead, "")
 	}
+	return " "
+}
 
-	if !ce.format.Compact && len(arr) > 0 {
-		ce.w.Write([]byte("\n"))
-		ce.w.Write([]byte(strings.Repeat(ce.format.Indent, depth)))
+// lineIndent returns the indentation of the last line in trivia, or fallback
+// if the trivia does not contain a line break.
+func lineIndent(trivia, fallback string) string {
+	i := strings.LastIndexByte(trivia, '\n')
+	if i < 0 {
+		return fallback
 	}
-
-	ce.w.Write([]byte("]"))
-	return nil
+	line := trivia[i+1:]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
 }
 
-func (ce *customEncoder) encodeStruct(v reflect.Value, depth int) error {
-	if v.Kind() == reflect.Pointer {
-		v = v.Elem()
+func (ce *customEncoder) encodeOrderedMap(om *OrderedMap, n *node, indent string) error {
+	if n != nil && n.kind != kindObject {
+		n = nil
+	}
+	if ce.enterLine(n) {
+		defer func() { ce.oneLine = false }()
 	}
 
-	t := v.Type()
-	om := NewOrderedMap()
-
-	for i := 0; i < v.NumField(); i++ {
-		field := t.Field(i)
-		fieldValue := v.Field(i)
-
-		jsonTag := field.Tag.Get("json")
-		if jsonTag == "-" {
-			continue
-		}
-
-		name := field.Name
-		if jsonTag != "" {
-			parts := strings.Split(jsonTag, ",")
-			if parts[0] != "" {
-				name = parts[0]
-			}
+	ce.w.Write([]byte("{"))
 
-			if strings.Contains(jsonTag, "omitempty") && isEmptyValue(fieldValue) {
-				continue
+	trail := ""
+	count, shadow := 0, 0
+	for _, key := range om.Keys {
+		m, pos := n.lookup(key)
+
+		// Members overwritten by a later one with the same key are written
+		// in their place as long as the key is there
+		for ; shadow < pos; shadow++ {
+			v, ok := n.shadowed[shadow]
+			if sm := n.members[shadow]; ok && om.Values[sm.key] != nil {
+				var err error
+				if trail, err = ce.encodeMember(n, sm, shadow, count, sm.key, &OrderedValue{Value: v}, trail, indent); err != nil {
+					return err
+				}
+				count++
 			}
 		}
 
-		om.Set(name, fieldValue.Interface(), i)
+		var err error
+		if trail, err = ce.encodeMember(n, m, pos, count, key, om.Values[key], trail, indent); err != nil {
+			return err
+		}
+		count++
 	}
 
-	return ce.encodeOrderedMap(om, depth)
+	if n != nil && n.trailingComma && count > 0 {
+		ce.w.Write([]byte(","))
+	}
+	ce.writeTrivia(trail, ce.closeLead(n, count, indent))
+	ce.w.Write([]byte("}"))
+	return nil
 }
 
-// Parse reads JSON from reader and parses it into typed and untyped data
-func Parse[T interface{}](r io.Reader, typedData T) (*Document[T], error) {
-	// Read all data
-	data, err := io.ReadAll(r)
-	if err != nil {
-		return nil, err
+// encodeMember writes the i-th member of an object. m is the member at index
+// pos of the source object n if there is one, trail the trail of the member
+// before. It returns the trail of this member.
+func (ce *customEncoder) encodeMember(n *node, m *member, pos, i int, key string, ov *OrderedValue, trail, indent string) (string, error) {
+	if i > 0 {
+		ce.w.Write([]byte(","))
 	}
 
-	// Detect format
-	format := detectFormat(data)
+	lead := ce.memberLead(n, m, pos, i, indent)
+	ce.writeTrivia(trail, lead)
+	childIndent := lineIndent(lead, indent+ce.format.Indent)
 
-	// Parse JSON with order preservation
-	ordered, err := parseOrdered(bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-
-	doc := &Document[T]{
-		TypedData:   typedData,
-		Format:      format,
-		OriginalMap: ordered,
+	// Write key
+	var valueNode *node
+	if m != nil {
+		io.WriteString(ce.w, m.rawKey)
+		io.WriteString(ce.w, ce.inline(m.preColon, childIndent))
+		ce.w.Write([]byte(":"))
+		if ce.reformat && ce.format.SpaceAfterColon {
+			ce.w.Write([]byte(" "))
+		}
+		io.WriteString(ce.w, ce.inline(m.postColon, childIndent))
+		valueNode = m.value
+	} else {
+		if err := ce.encodeKey(key); err != nil {
+			return "", err
+		}
+		ce.w.Write([]byte(":"))
+		if ce.format.SpaceAfterColon {
+			ce.w.Write([]byte(" "))
+		}
 	}
 
-	// If typedData is provided, unmarshal into it
-	if !isNil(typedData) {
-		if err := json.Unmarshal(data, typedData); err != nil {
-			return nil, err
+	// Write value
+	if ov != nil {
+		if err := ce.encode(ov.Value, valueNode, childIndent); err != nil {
+			return "", err
 		}
-
-		// Extract rest fields
-		doc.Rest = extractRest(ordered, typedData)
-	} else {
-		// No typed data, everything goes to rest
-		doc.Rest = ordered
 	}
 
-	return doc, nil
+	if m != nil {
+		io.WriteString(ce.w, ce.inline(m.preComma, childIndent))
+	}
+	return ce.trail(m), nil
 }
 
-// parseOrdered parses JSON preserving key order
-func parseOrdered(r io.Reader) (*OrderedMap, error) {
-	decoder := json.NewDecoder(r)
-	decoder.UseNumber()
-
-	t, err := decoder.Token()
+// encodeMap encodes a map with keys that encoding/json writes as object keys.
+// Keys that exist in the original object keep their position, new keys are
+// appended in sorted order.
+func (ce *customEncoder) encodeMap(v reflect.Value, n *node, indent string) error {
+	byKey, err := mapKeys(v)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	om := NewOrderedMap()
+	if n != nil && n.kind == kindObject {
+		for _, m := range n.members {
+			if k, ok := byKey[m.key]; ok {
+				om.Set(m.key, v.MapIndex(k).Interface(), len(om.Keys))
+			}
+		}
 	}
 
-	if t != json.Delim('{') {
-		return nil, fmt.Errorf("expected object, got %v", t)
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		if _, exists := om.Values[k]; !exists {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		om.Set(k, v.MapIndex(byKey[k]).Interface(), len(om.Keys))
 	}
 
-	return parseObject(decoder)
+	return ce.encodeOrderedMap(om, n, indent)
 }
 
-// parseObject parses a JSON object preserving key order
-func parseObject(decoder *json.Decoder) (*OrderedMap, error) {
-	om := NewOrderedMap()
-	order := 0
+// sliceValues converts a typed slice or array to its elements
+func sliceValues(v reflect.Value) []interface{} {
+	arr := make([]interface{}, v.Len())
+	for i := range arr {
+		arr[i] = v.Index(i).Interface()
+	}
+	return arr
+}
 
-	for {
-		t, err := decoder.Token()
-		if err != nil {
-			return nil, err
-		}
+// encodeArray encodes the elements of an array. source holds the index of the
+// original element each value was matched with, nil means matching by index.
+func (ce *customEncoder) encodeArray(arr []interface{}, source []int, n *node, indent string) error {
+	if n != nil && n.kind != kindArray {
+		n = nil
+	}
+	if ce.enterLine(n) {
+		defer func() { ce.oneLine = false }()
+	}
 
-		if t == json.Delim('}') {
-			break
-		}
+	ce.w.Write([]byte("["))
 
-		key, ok := t.(string)
-		if !ok {
-			return nil, fmt.Errorf("expected string key, got %v", t)
+	trail := ""
+	for i, item := range arr {
+		if i > 0 {
+			ce.w.Write([]byte(","))
 		}
 
-		value, err := parseValue(decoder)
-		if err != nil {
-			return nil, err
+		pos := i
+		if source != nil {
+			pos = source[i]
+		}
+		m := n.element(pos)
+		lead := ce.memberLead(n, m, pos, i, indent)
+		ce.writeTrivia(trail, lead)
+		childIndent := lineIndent(lead, indent+ce.format.Indent)
+
+		var valueNode *node
+		if m != nil {
+			valueNode = m.value
+		}
+		if err := ce.encode(item, valueNode, childIndent); err != nil {
+			return err
 		}
 
-		om.Set(key, value, order)
-		order++
+		if m != nil {
+			io.WriteString(ce.w, ce.inline(m.preComma, childIndent))
+		}
+		trail = ce.trail(m)
 	}
 
-	return om, nil
+	if n != nil && n.trailingComma && len(arr) > 0 {
+		ce.w.Write([]byte(","))
+	}
+	ce.writeTrivia(trail, ce.closeLead(n, len(arr), indent))
+	ce.w.Write([]byte("]"))
+	return nil
 }
 
-// parseValue parses any JSON value
-func parseValue(decoder *json.Decoder) (interface{}, error) {
-	t, err := decoder.Token()
-	if err != nil {
-		return nil, err
+func (ce *customEncoder) encodeStruct(v reflect.Value, n *node, indent string) error {
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
 	}
 
-	switch v := t.(type) {
-	case json.Delim:
-		switch v {
-		case json.Delim('{'):
-			return parseObject(decoder)
-		case json.Delim('['):
-			return parseArray(decoder)
-		default:
-			return nil, fmt.Errorf("unexpected delimiter: %v", v)
-		}
-	case string:
-		return v, nil
-	case json.Number:
-		// Try to convert to appropriate numeric type
-		if i, err := v.Int64(); err == nil {
-			return float64(i), nil
-		}
-		if f, err := v.Float64(); err == nil {
-			return f, nil
+	om := NewOrderedMap()
+	fields := cachedTypeFields(v.Type())
+	for i := range fields.list {
+		f := &fields.list[i]
+		fieldValue, ok := f.value(v)
+		if !ok || f.omit(fieldValue) {
+			continue
 		}
-		return v.String(), nil
-	case bool:
-		return v, nil
-	case nil:
-		return nil, nil
-	default:
-		return v, nil
+		om.Set(f.name, f.encoded(fieldValue), len(om.Keys))
 	}
+
+	return ce.encodeOrderedMap(om, n, indent)
 }
 
-// parseArray parses a JSON array
-func parseArray(decoder *json.Decoder) ([]interface{}, error) {
-	var arr []interface{}
+// Dialect selects the JSON syntax accepted by Parse
+type Dialect int
+
+const (
+	// JSON is strict RFC 8259 JSON
+	JSON Dialect = iota
+	// JSONC is JSON with // line comments and /* */ block comments, as used
+	// by tsconfig.json and VS Code settings
+	JSONC
+	// JSON5 adds unquoted identifier keys, single-quoted and multi-line
+	// strings, hexadecimal numbers, leading and trailing decimal points,
+	// explicit plus signs, Infinity and NaN to JSONC and allows trailing
+	// commas. Infinity and NaN decode into float fields of TypedData and as
+	// null into anything else.
+	JSON5
+)
 
-	for {
-		if !decoder.More() {
-			// Read closing bracket
-			t, err := decoder.Token()
-			if err != nil {
-				return nil, err
-			}
-			if t != json.Delim(']') {
-				return nil, fmt.Errorf("expected ], got %v", t)
-			}
-			break
-		}
+// Option configures Parse
+type Option func(*options)
 
-		value, err := parseValue(decoder)
-		if err != nil {
-			return nil, err
-		}
+type options struct {
+	dialect        Dialect
+	trailingCommas bool
+}
 
-		arr = append(arr, value)
-	}
+func (o options) comments() bool {
+	return o.dialect == JSONC || o.dialect == JSON5
+}
 
-	return arr, nil
+func (o options) json5() bool {
+	return o.dialect == JSON5
 }
 
-// detectFormat analyzes JSON formatting
-func detectFormat(data []byte) Format {
-	format := Format{
-		Compact:         true,
-		SpaceAfterColon: false,
-		SpaceAfterComma: false,
+func (o options) allowTrailingCommas() bool {
+	return o.trailingCommas || o.dialect == JSON5
+}
+
+// WithDialect makes Parse accept the given dialect. Comments are attached to
+// the neighbouring keys and values and written back in place.
+func WithDialect(dialect Dialect) Option {
+	return func(o *options) {
+		o.dialect = dialect
 	}
+}
 
-	// Check for newlines (non-compact)
-	if bytes.Contains(data, []byte("\n")) {
-		format.Compact = false
+// WithTrailingCommas makes Parse accept a comma after the last member of an
+// object or array. Whether a container had one is remembered and reproduced
+// by Write, also when members are appended or removed at the end.
+func WithTrailingCommas() Option {
+	return func(o *options) {
+		o.trailingCommas = true
+	}
+}
 
-		// Detect indentation
-		lines := bytes.Split(data, []byte("\n"))
-		for i, line := range lines {
-			if i == 0 {
-				continue
-			}
+// Parse reads JSON from reader and parses it into typed and untyped data
+func Parse[T interface{}](r io.Reader, typedData T, opts ...Option) (*Document[T], error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
 
-			trimmed := bytes.TrimLeftFunc(line, unicode.IsSpace)
-			if len(trimmed) > 0 && len(line) > len(trimmed) {
-				indent := line[:len(line)-len(trimmed)]
-				if bytes.HasPrefix(indent, []byte("  ")) {
-					format.Indent = "  "
-				} else if bytes.HasPrefix(indent, []byte("    ")) {
-					format.Indent = "    "
-				} else if bytes.HasPrefix(indent, []byte("\t")) {
-					format.Indent = "\t"
-				}
-				break
-			}
-		}
+	// Read all data
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check for space after colon
-	if bytes.Contains(data, []byte(": ")) {
-		format.SpaceAfterColon = true
+	// Parse JSON into a lossless syntax tree and an ordered value tree,
+	// detecting the format on the way
+	tree, value, err := parseTree(data, o)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check for space after comma
-	if bytes.Contains(data, []byte(", ")) {
-		format.SpaceAfterComma = true
+	ordered, _ := value.(*OrderedMap)
+	doc := &Document[T]{
+		TypedData:   typedData,
+		Format:      tree.format,
+		OriginalMap: ordered,
+		Original:    value,
+		tree:        tree,
 	}
 
-	if len(data) > 0 && data[len(data)-1] == '\n' {
-		format.TrailingNewline = true
+	// If typedData is provided, decode the tree into it
+	if isBound(typedData) {
+		// Value types like slices are decoded in place
+		var target interface{} = typedData
+		if isValueType[T]() {
+			target = &doc.TypedData
+		}
+		if err := decodeTree(tree, target); err != nil {
+			return nil, err
+		}
+
+		// Extract rest fields
+		if ordered != nil {
+			doc.Rest = extractRest(ordered, doc.TypedData)
+		}
+	} else {
+		// No typed data, everything goes to rest
+		doc.Rest = ordered
 	}
 
-	return format
+	return doc, nil
 }
 
 // extractRest extracts fields not present in typed data
@@ -657,33 +1030,16 @@ func extractRest(om *OrderedMap, typedData interface{}) *OrderedMap {
 	if v.Kind() != reflect.Struct {
 		return om
 	}
-
-	t := v.Type()
-	typedFields := make(map[string]bool)
-
-	// Collect all typed field names
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		jsonTag := field.Tag.Get("json")
-
-		if jsonTag == "-" {
-			continue
-		}
-
-		name := field.Name
-		if jsonTag != "" {
-			parts := strings.Split(jsonTag, ",")
-			if parts[0] != "" {
-				name = parts[0]
-			}
-		}
-
-		typedFields[name] = true
+	if isUnmarshaler(v.Type()) {
+		// The type decodes the whole object itself
+		return rest
 	}
 
+	fields := cachedTypeFields(v.Type())
+
 	// Add non-typed fields to rest
 	for _, key := range om.Keys {
-		if !typedFields[key] {
+		if fields.lookup(key) == nil {
 			if ov, ok := om.Values[key]; ok {
 				rest.Set(key, ov.Value, ov.Order)
 			}
@@ -702,7 +1058,7 @@ func isEmptyValue(v reflect.Value) bool {
 		return !v.Bool()
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return v.Int() == 0
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		return v.Uint() == 0
 	case reflect.Float32, reflect.Float64:
 		return v.Float() == 0
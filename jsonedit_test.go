@@ -1,8 +1,15 @@
 package jsonedit_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	jsonedit "github.com/tsukinoko-kun/jsonedit"
 )
@@ -198,3 +205,2362 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteLossless(t *testing.T) {
+	tests := []struct {
+		name string
+		r    string
+	}{
+		{
+			name: "irregular whitespace",
+			r:    "{\n    \"foo\" : \"bar\",\n  \"bar\":42,\"Baz\":   true,\n\t\"qux\": {\"a\": [1,2,  3], \"b\": { }}\r\n}\n\n",
+		},
+		{
+			name: "escapes and number literals",
+			r:    `{"foo": "café \/ <b>", "bar": 100, "Baz": false, "exp": 1.0e2, "big": 9007199254740993, "small": 0.10}`,
+		},
+		{
+			name: "leading whitespace and no trailing newline",
+			r:    "  \n{\"foo\":\"bar\",\"bar\":0,\"Baz\":false,\"list\":[ ]}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := jsonedit.Parse(strings.NewReader(tt.r), &SimpleStruct{})
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			got, err := doc.String()
+			if err != nil {
+				t.Fatalf("String() failed: %v", err)
+			}
+			if got != tt.r {
+				t.Errorf("Got %q want %q", got, tt.r)
+			}
+		})
+	}
+}
+
+func TestWriteOnlyChangedValues(t *testing.T) {
+	r := "{\n    \"foo\" : \"bar\",\n  \"bar\":42,\n  \"list\": [1,2,  3],\n  \"Baz\":   true\n}\n"
+	doc, err := jsonedit.Parse(strings.NewReader(r), &SimpleStruct{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	doc.TypedData.Foo = "changed"
+	doc.TypedData.Baz = false
+	list, _ := doc.Rest.Get("list")
+	doc.Rest.Set("list", append(list.([]interface{}), 4.0), 0)
+
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := "{\n    \"foo\" : \"changed\",\n  \"bar\":42,\n  \"list\": [1,2,  3,4],\n  \"Baz\":   false\n}\n"
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	doc.Format.Indent = "\t"
+	doc.Format.SpaceAfterComma = false
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want = "{\n\t\"foo\": \"changed\",\n\t\"bar\": 42,\n\t\"list\": [\n\t\t1,\n\t\t2,\n\t\t3,\n\t\t4\n\t],\n\t\"Baz\": false\n}\n"
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+type TsConfig struct {
+	CompilerOptions struct {
+		Target string `json:"target"`
+		Strict bool   `json:"strict"`
+	} `json:"compilerOptions"`
+	Include []string `json:"include"`
+}
+
+func TestNewValuesInSingleLineContainers(t *testing.T) {
+	r := "{\n  \"x\": {\"y\": 1, \"z\": 2},\n  \"list\": [ 1 ],\n  \"empty\": {}\n}\n"
+	doc, err := jsonedit.Parse(strings.NewReader(r), (*struct{})(nil))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	patch := `{"x": {"w": {"v": 1, "u": [2, {}]}}, "list": [1, {"a": 1}], "empty": {"e": {"f": 1}}}`
+	if err := doc.MergePatch(strings.NewReader(patch)); err != nil {
+		t.Fatalf("MergePatch() failed: %v", err)
+	}
+
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	// Empty containers do not tell how to lay out their members
+	want := "{\n  \"x\": {\"y\": 1, \"z\": 2, \"w\": {\"v\": 1, \"u\": [2, {}]}},\n  \"list\": [ 1, {\"a\": 1} ],\n  \"empty\": {\n    \"e\": {\n      \"f\": 1\n    }\n  }\n}\n"
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	r := "{\r\n  \"name\": \"app\",\r\n  \"devDependencies\": {}\r\n}\r\n"
+	doc, err := jsonedit.Parse(strings.NewReader(r), &PackageJson{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if !doc.Format.CRLF {
+		t.Errorf("Got format %+v", doc.Format)
+	}
+	doc.TypedData.SetDependency("y", "2")
+	doc.TypedData.DevDependencies["z"] = "3"
+
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := "{\r\n  \"name\": \"app\",\r\n  \"dependencies\": {\r\n    \"y\": \"2\"\r\n  },\r\n  \"devDependencies\": {\r\n    \"z\": \"3\"\r\n  }\r\n}\r\n"
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	// Reformatting keeps the line breaks of Format
+	doc.Format.Indent = "\t"
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if strings.Count(got, "\n") != strings.Count(got, "\r\n") {
+		t.Errorf("Got mixed line breaks in %q", got)
+	}
+
+	e := jsonedit.NewEditor()
+	e.Format = jsonedit.Format{Indent: "  "}
+	if err := e.Set("/devDependencies/z", "3"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	var b strings.Builder
+	if err := e.Apply(&b, strings.NewReader(r)); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+	want = "{\r\n  \"name\": \"app\",\r\n  \"devDependencies\": {\r\n    \"z\": \"3\"\r\n  }\r\n}\r\n"
+	if got := b.String(); got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestParseJSONC(t *testing.T) {
+	r := `// tsconfig for the app
+{
+  /* compiler settings */
+  "compilerOptions": {
+    "target": "es2020", // keep in sync with the browserslist
+    "strict": false
+    // "noEmit": true
+  },
+  "include": ["src"] // sources only
+}
+`
+	if _, err := jsonedit.Parse(strings.NewReader(r), &TsConfig{}); err == nil {
+		t.Fatal("Parse() accepted comments in strict JSON")
+	}
+
+	doc, err := jsonedit.Parse(strings.NewReader(r), &TsConfig{}, jsonedit.WithDialect(jsonedit.JSONC))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if doc.TypedData.CompilerOptions.Target != "es2020" {
+		t.Errorf("Got target %q want %q", doc.TypedData.CompilerOptions.Target, "es2020")
+	}
+
+	leading, trailing := doc.OriginalMap.Comments("compilerOptions")
+	if len(leading) != 1 || leading[0] != "/* compiler settings */" || len(trailing) != 0 {
+		t.Errorf("Got comments %q %q", leading, trailing)
+	}
+	_, trailing = doc.OriginalMap.Comments("include")
+	if len(trailing) != 1 || trailing[0] != "// sources only" {
+		t.Errorf("Got trailing comments %q", trailing)
+	}
+
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if got != r {
+		t.Errorf("Got %q want %q", got, r)
+	}
+
+	doc.TypedData.CompilerOptions.Target = "es2022"
+	doc.TypedData.Include = append(doc.TypedData.Include, "test")
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `// tsconfig for the app
+{
+  /* compiler settings */
+  "compilerOptions": {
+    "target": "es2022", // keep in sync with the browserslist
+    "strict": false
+    // "noEmit": true
+  },
+  "include": ["src", "test"] // sources only
+}
+`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	doc.Format.Indent = "\t"
+	doc.Format.SpaceAfterComma = false
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want = "// tsconfig for the app\n{\n\t/* compiler settings */\n\t\"compilerOptions\": {\n\t\t\"target\": \"es2022\", // keep in sync with the browserslist\n\t\t\"strict\": false\n\t\t// \"noEmit\": true\n\t},\n\t\"include\": [\n\t\t\"src\",\n\t\t\"test\"\n\t] // sources only\n}\n"
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestParseJSONCRemoveCommentedMember(t *testing.T) {
+	r := "{\n  // the name\n  \"name\": \"app\", // inline\n  \"version\": \"1.0.0\" /* last */\n}"
+	doc, err := jsonedit.Parse(strings.NewReader(r), (*SimpleStruct)(nil), jsonedit.WithDialect(jsonedit.JSONC))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	doc.Rest.Keys = doc.Rest.Keys[1:]
+	delete(doc.Rest.Values, "name")
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := "{\n  \"version\": \"1.0.0\" /* last */\n}"
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	doc.Rest.Set("name", "app", 2)
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want = "{\n  \"version\": \"1.0.0\", /* last */\n  // the name\n  \"name\": \"app\" // inline\n}"
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestParseTrailingCommas(t *testing.T) {
+	r := `{
+  "dependencies": {
+    "react": "^18.2.0",
+    "zod": "^3.21.4",
+  },
+  "devDependencies": {
+    "eslint": "^8.46.0",
+    "prettier": "^3.0.0"
+  },
+  "files": ["dist", "src",],
+}
+`
+	if _, err := jsonedit.Parse(strings.NewReader(r), &PackageJson{}); err == nil {
+		t.Fatal("Parse() accepted trailing commas in strict JSON")
+	}
+
+	doc, err := jsonedit.Parse(strings.NewReader(r), &PackageJson{}, jsonedit.WithTrailingCommas())
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if got != r {
+		t.Errorf("Got %q want %q", got, r)
+	}
+
+	doc.TypedData.SetDependency("zz-top", "1.0.0")
+	doc.TypedData.DeleteDevDependency("prettier")
+	files, _ := doc.Rest.Get("files")
+	doc.Rest.Set("files", files.([]interface{})[:1], 0)
+
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `{
+  "dependencies": {
+    "react": "^18.2.0",
+    "zod": "^3.21.4",
+    "zz-top": "1.0.0",
+  },
+  "devDependencies": {
+    "eslint": "^8.46.0"
+  },
+  "files": ["dist",],
+}
+`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+type Json5Config struct {
+	Name    string  `json:"name"`
+	Mask    int     `json:"mask"`
+	Ratio   float64 `json:"ratio"`
+	Tagline string  `json:"tagline"`
+	Owner   string  `json:"owner,omitempty"`
+}
+
+func TestParseJSON5(t *testing.T) {
+	r := `// JSON5 config
+{
+  name: 'app',
+  "quoted-key": 'single',
+  mask: 0xFF,
+  ratio: .5,
+  scale: +5.,
+  limit: Infinity,
+  nothing: NaN,
+  tagline: 'It\'s \
+multi-line',
+  list: [1, 2, 3,],
+}
+`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &Json5Config{}, jsonedit.WithDialect(jsonedit.JSON5))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if got := *doc.TypedData; got.Name != "app" || got.Mask != 255 || got.Ratio != 0.5 || got.Tagline != "It's multi-line" {
+		t.Errorf("Got typed data %+v", got)
+	}
+	if !doc.Format.UnquotedKeys || !doc.Format.SingleQuotes {
+		t.Errorf("Got format %+v", doc.Format)
+	}
+
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if got != r {
+		t.Errorf("Got %q want %q", got, r)
+	}
+
+	doc.TypedData.Name = "it's new"
+	doc.TypedData.Mask = 15
+	doc.TypedData.Owner = "ops team"
+	doc.Rest.Set("quoted-key", `say "hi"`, 1)
+
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `// JSON5 config
+{
+  name: 'it\'s new',
+  "quoted-key": 'say "hi"',
+  mask: 15,
+  ratio: .5,
+  scale: +5.,
+  limit: Infinity,
+  nothing: NaN,
+  tagline: 'It\'s \
+multi-line',
+  list: [1, 2, 3,],
+  owner: 'ops team',
+}
+`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestNumberPreservation(t *testing.T) {
+	r := `{"id": 9007199254740993, "one": 1.0, "thousand": 1e3, "tenth": 0.10, "bar": 42}`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &SimpleStruct{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	id, _ := doc.Rest.Get("id")
+	if id != json.Number("9007199254740993") {
+		t.Errorf("Got id %#v want json.Number", id)
+	}
+
+	doc.Format.SpaceAfterColon = false
+	doc.Format.SpaceAfterComma = false
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `{"id":9007199254740993,"one":1.0,"thousand":1e3,"tenth":0.10,"foo":"","bar":42,"Baz":false}`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	doc.Format.SpaceAfterColon = true
+	doc.Format.SpaceAfterComma = true
+	doc.TypedData.Bar = 43
+	doc.Rest.Set("tenth", json.Number("0.25"), 3)
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want = `{"id": 9007199254740993, "one": 1.0, "thousand": 1e3, "tenth": 0.25, "foo": "", "bar": 43, "Baz": false}`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	r := `{"foo": "caf\u00e9 \/ <b>", "bar": 1, "caf\u00e9": "café", "Baz": true}`
+
+	tests := []struct {
+		name   string
+		escape jsonedit.EscapePolicy
+		want   string
+	}{
+		{
+			name:   "minimal",
+			escape: jsonedit.EscapeMinimal,
+			want:   `{"foo": "<é> & \"😀\"", "bar": 1, "caf\u00e9": "café", "Baz": true}`,
+		},
+		{
+			name:   "html",
+			escape: jsonedit.EscapeHTML,
+			want:   `{"foo": "\u003cé\u003e \u0026 \"😀\"", "bar": 1, "caf\u00e9": "café", "Baz": true}`,
+		},
+		{
+			name:   "ascii",
+			escape: jsonedit.EscapeASCII,
+			want:   `{"foo": "<\u00e9> & \"\ud83d\ude00\"", "bar": 1, "caf\u00e9": "café", "Baz": true}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := jsonedit.Parse(strings.NewReader(r), &SimpleStruct{})
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			doc.Format.Escape = tt.escape
+
+			got, err := doc.String()
+			if err != nil {
+				t.Fatalf("String() failed: %v", err)
+			}
+			if got != r {
+				t.Errorf("Got %q want %q", got, r)
+			}
+
+			doc.TypedData.Foo = `<é> & "😀"`
+			got, err = doc.String()
+			if err != nil {
+				t.Fatalf("String() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSurrogateEscapes(t *testing.T) {
+	inputs := []string{
+		`{"foo": "\ud83d\ude00x", "\ud83d\ude00": "\ud83d\ude01"}`,
+		`{"foo": "\ud83dx", "\ude00": "\ud83dA"}`,
+		`{"foo": "\ud83d\ud83d\ude00", "k": "\ud83d"}`,
+	}
+	for _, r := range inputs {
+		var want map[string]string
+		if err := json.Unmarshal([]byte(r), &want); err != nil {
+			t.Fatalf("Unmarshal() failed: %v", err)
+		}
+		doc, err := jsonedit.Parse(strings.NewReader(r), &struct {
+			Foo string `json:"foo"`
+		}{})
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+		if got := doc.TypedData.Foo; got != want["foo"] {
+			t.Errorf("Got %q want %q", got, want["foo"])
+		}
+		for key, value := range want {
+			if got, ok := doc.OriginalMap.Get(key); !ok || got != value {
+				t.Errorf("Got %q want %q for key %q", got, value, key)
+			}
+		}
+		if got, err := doc.String(); err != nil || got != r {
+			t.Errorf("Got %q want %q", got, r)
+		}
+	}
+}
+
+func TestParseRootValues(t *testing.T) {
+	t.Run("typed array", func(t *testing.T) {
+		r := "[\n  \"eslint:recommended\",\n  \"prettier\" // last\n]\n"
+		doc, err := jsonedit.Parse[[]string](strings.NewReader(r), nil, jsonedit.WithDialect(jsonedit.JSONC))
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+		if len(doc.TypedData) != 2 || doc.OriginalMap != nil || doc.Rest != nil {
+			t.Fatalf("Got document %+v", doc)
+		}
+
+		got, err := doc.String()
+		if err != nil {
+			t.Fatalf("String() failed: %v", err)
+		}
+		if got != r {
+			t.Errorf("Got %q want %q", got, r)
+		}
+
+		doc.TypedData = append(doc.TypedData, "plugin:react/recommended")
+		got, err = doc.String()
+		if err != nil {
+			t.Fatalf("String() failed: %v", err)
+		}
+		want := "[\n  \"eslint:recommended\",\n  \"prettier\", // last\n  \"plugin:react/recommended\"\n]\n"
+		if got != want {
+			t.Errorf("Got %q want %q", got, want)
+		}
+	})
+
+	t.Run("typed array of structs", func(t *testing.T) {
+		r := `[{"foo": "a", "bar": 1, "Baz": true}, {"foo": "b", "bar": 2, "Baz": false}]`
+		doc, err := jsonedit.Parse(strings.NewReader(r), &[]SimpleStruct{})
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+
+		(*doc.TypedData)[1].Bar = 3
+		got, err := doc.String()
+		if err != nil {
+			t.Fatalf("String() failed: %v", err)
+		}
+		want := `[{"foo": "a", "bar": 1, "Baz": true}, {"foo": "b", "bar": 3, "Baz": false}]`
+		if got != want {
+			t.Errorf("Got %q want %q", got, want)
+		}
+	})
+
+	t.Run("untyped array", func(t *testing.T) {
+		r := `[1, "two", {"three": 3}]`
+		doc, err := jsonedit.Parse(strings.NewReader(r), (*[]interface{})(nil))
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+
+		doc.Original = append(doc.Original.([]interface{}), nil)
+		got, err := doc.String()
+		if err != nil {
+			t.Fatalf("String() failed: %v", err)
+		}
+		want := `[1, "two", {"three": 3}, null]`
+		if got != want {
+			t.Errorf("Got %q want %q", got, want)
+		}
+	})
+
+	t.Run("scalar", func(t *testing.T) {
+		r := " \"caf\\u00e9\"\n"
+		doc, err := jsonedit.Parse[string](strings.NewReader(r), "")
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+		if doc.TypedData != "café" {
+			t.Errorf("Got %q want %q", doc.TypedData, "café")
+		}
+
+		got, err := doc.String()
+		if err != nil {
+			t.Fatalf("String() failed: %v", err)
+		}
+		if got != r {
+			t.Errorf("Got %q want %q", got, r)
+		}
+	})
+}
+
+type NestedPackageJson struct {
+	Name    string `json:"name"`
+	Scripts struct {
+		Test string `json:"test"`
+		Lint string `json:"lint,omitempty"`
+	} `json:"scripts"`
+	Config *struct {
+		Build struct {
+			Target string `json:"target"`
+			Minify bool   `json:"minify"`
+		} `json:"build"`
+	} `json:"config"`
+}
+
+func TestNestedRestPreservation(t *testing.T) {
+	r := `{
+  "name": "app",
+  "scripts": {
+    "build": "tsc",
+    "test": "jest",
+    "lint": "eslint ."
+  },
+  "config": {
+    "build": {
+      "minify": false,
+      "sourcemap": true,
+      "target": "es2020"
+    },
+    "port": 8080
+  }
+}
+`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &NestedPackageJson{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if got != r {
+		t.Errorf("Got %q want %q", got, r)
+	}
+
+	doc.TypedData.Scripts.Test = "vitest"
+	doc.TypedData.Scripts.Lint = ""
+	doc.TypedData.Config.Build.Target = "es2022"
+	doc.TypedData.Config.Build.Minify = true
+
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `{
+  "name": "app",
+  "scripts": {
+    "build": "tsc",
+    "test": "vitest"
+  },
+  "config": {
+    "build": {
+      "minify": true,
+      "sourcemap": true,
+      "target": "es2022"
+    },
+    "port": 8080
+  }
+}
+`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+type Workspace struct {
+	Version string `json:"version"`
+}
+
+type MapFields struct {
+	Ports      map[string]int         `json:"ports"`
+	Settings   map[string]interface{} `json:"settings"`
+	Workspaces map[string]Workspace   `json:"workspaces"`
+}
+
+func TestMergeMapsInOriginalOrder(t *testing.T) {
+	r := `{
+  "ports": {"web": 8080, "api": 3000, "db": 5432},
+  "settings": {"zoom": 1.5, "theme": {"name": "dark", "accent": "blue"}, "autosave": true},
+  "workspaces": {
+    "web": {"version": "1.0.0", "private": true},
+    "api": {"version": "2.0.0", "private": false}
+  }
+}`
+	for i := 0; i < 10; i++ {
+		doc, err := jsonedit.Parse(strings.NewReader(r), &MapFields{})
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+
+		got, err := doc.String()
+		if err != nil {
+			t.Fatalf("String() failed: %v", err)
+		}
+		if got != r {
+			t.Fatalf("Got %q want %q", got, r)
+		}
+
+		delete(doc.TypedData.Ports, "api")
+		doc.TypedData.Ports["web"] = 80
+		doc.TypedData.Settings["theme"].(map[string]interface{})["name"] = "light"
+		doc.TypedData.Workspaces["api"] = Workspace{Version: "2.1.0"}
+
+		got, err = doc.String()
+		if err != nil {
+			t.Fatalf("String() failed: %v", err)
+		}
+		want := `{
+  "ports": {"web": 80, "db": 5432},
+  "settings": {"zoom": 1.5, "theme": {"name": "light", "accent": "blue"}, "autosave": true},
+  "workspaces": {
+    "web": {"version": "1.0.0", "private": true},
+    "api": {"version": "2.1.0", "private": false}
+  }
+}`
+		if got != want {
+			t.Fatalf("Got %q want %q", got, want)
+		}
+	}
+}
+
+func TestMergeRootMap(t *testing.T) {
+	r := `{"b": 2, "a": 1, "c": 3}`
+	doc, err := jsonedit.Parse[map[string]int](strings.NewReader(r), nil)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	delete(doc.TypedData, "a")
+	doc.TypedData["c"] = 4
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if want := `{"b": 2, "c": 4}`; got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestNewMapKeyPlacement(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		placement jsonedit.Placement
+		want      string
+	}{
+		{
+			name:  "append sorted",
+			input: `{"dependencies": {"react": "^18.0.0", "axios": "^1.0.0"}, "devDependencies": {}}`,
+			want:  `{"dependencies": {"react": "^18.0.0", "axios": "^1.0.0", "lodash": "^4.0.0", "zod": "^3.0.0"}, "devDependencies": {}}`,
+		},
+		{
+			name:      "insert sorted",
+			input:     `{"dependencies": {"axios": "^1.0.0", "react": "^18.0.0"}, "devDependencies": {}}`,
+			placement: jsonedit.Placement{Mode: jsonedit.InsertSorted},
+			want:      `{"dependencies": {"axios": "^1.0.0", "lodash": "^4.0.0", "react": "^18.0.0", "zod": "^3.0.0"}, "devDependencies": {}}`,
+		},
+		{
+			name:      "insert sorted into unsorted keys",
+			input:     `{"dependencies": {"react": "^18.0.0", "axios": "^1.0.0"}, "devDependencies": {}}`,
+			placement: jsonedit.Placement{Mode: jsonedit.InsertSorted},
+			want:      `{"dependencies": {"react": "^18.0.0", "axios": "^1.0.0", "lodash": "^4.0.0", "zod": "^3.0.0"}, "devDependencies": {}}`,
+		},
+		{
+			name:      "insert after anchor",
+			input:     `{"dependencies": {"react": "^18.0.0", "axios": "^1.0.0"}, "devDependencies": {}}`,
+			placement: jsonedit.Placement{Mode: jsonedit.InsertAfter, Anchor: "react"},
+			want:      `{"dependencies": {"react": "^18.0.0", "lodash": "^4.0.0", "zod": "^3.0.0", "axios": "^1.0.0"}, "devDependencies": {}}`,
+		},
+		{
+			name:      "missing anchor",
+			input:     `{"dependencies": {"react": "^18.0.0", "axios": "^1.0.0"}, "devDependencies": {}}`,
+			placement: jsonedit.Placement{Mode: jsonedit.InsertAfter, Anchor: "vue"},
+			want:      `{"dependencies": {"react": "^18.0.0", "axios": "^1.0.0", "lodash": "^4.0.0", "zod": "^3.0.0"}, "devDependencies": {}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				doc, err := jsonedit.Parse(strings.NewReader(tt.input), &PackageJson{})
+				if err != nil {
+					t.Fatalf("Parse() failed: %v", err)
+				}
+				doc.Placement = tt.placement
+				doc.TypedData.Dependencies["zod"] = "^3.0.0"
+				doc.TypedData.Dependencies["lodash"] = "^4.0.0"
+
+				got, err := doc.String()
+				if err != nil {
+					t.Fatalf("String() failed: %v", err)
+				}
+				if got != tt.want {
+					t.Fatalf("Got %q want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+type Person struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+type Contributors struct {
+	Contributors []Person `json:"contributors"`
+}
+
+func TestArrayElementPreservation(t *testing.T) {
+	r := `{
+  "contributors": [
+    {"name": "alice", "url": "https://alice.dev", "email": "alice@example.com"},
+    // maintainer
+    {"name": "bob", "email": "bob@example.com", "github": "bob"},
+    {"name": "carol"}
+  ]
+}`
+	tests := []struct {
+		name        string
+		identityKey string
+		edit        func(c *Contributors)
+		want        string
+	}{
+		{
+			name: "edit by index",
+			edit: func(c *Contributors) {
+				c.Contributors[1].Email = "bob@example.org"
+			},
+			want: `{
+  "contributors": [
+    {"name": "alice", "url": "https://alice.dev", "email": "alice@example.com"},
+    // maintainer
+    {"name": "bob", "email": "bob@example.org", "github": "bob"},
+    {"name": "carol"}
+  ]
+}`,
+		},
+		{
+			name: "append by index",
+			edit: func(c *Contributors) {
+				c.Contributors = append(c.Contributors, Person{Name: "dave"})
+			},
+			want: `{
+  "contributors": [
+    {"name": "alice", "url": "https://alice.dev", "email": "alice@example.com"},
+    // maintainer
+    {"name": "bob", "email": "bob@example.com", "github": "bob"},
+    {"name": "carol"},
+    {
+      "name": "dave"
+    }
+  ]
+}`,
+		},
+		{
+			name: "delete by index",
+			edit: func(c *Contributors) {
+				c.Contributors = c.Contributors[1:]
+			},
+			want: `{
+  "contributors": [
+    // maintainer
+    {"name": "bob", "email": "bob@example.com", "github": "bob"},
+    {"name": "carol"}
+  ]
+}`,
+		},
+		{
+			name: "delete and edit by index",
+			edit: func(c *Contributors) {
+				c.Contributors = c.Contributors[1:]
+				c.Contributors[0].Email = "bob@example.org"
+			},
+			want: `{
+  "contributors": [
+    // maintainer
+    {"name": "bob", "email": "bob@example.org", "github": "bob"},
+    {"name": "carol"}
+  ]
+}`,
+		},
+		{
+			name: "replace by index",
+			edit: func(c *Contributors) {
+				c.Contributors[0] = Person{Name: "dave"}
+			},
+			want: `{
+  "contributors": [
+    {
+      "name": "dave"
+    },
+    // maintainer
+    {"name": "bob", "email": "bob@example.com", "github": "bob"},
+    {"name": "carol"}
+  ]
+}`,
+		},
+		{
+			name:        "delete and insert by identity",
+			identityKey: "name",
+			edit: func(c *Contributors) {
+				c.Contributors = []Person{
+					{Name: "dave"},
+					c.Contributors[1],
+					c.Contributors[2],
+				}
+				c.Contributors[1].Email = "bob@example.org"
+			},
+			want: `{
+  "contributors": [
+    {
+      "name": "dave"
+    },
+    // maintainer
+    {"name": "bob", "email": "bob@example.org", "github": "bob"},
+    {"name": "carol"}
+  ]
+}`,
+		},
+		{
+			name:        "reorder by identity",
+			identityKey: "name",
+			edit: func(c *Contributors) {
+				c.Contributors[0], c.Contributors[2] = c.Contributors[2], c.Contributors[0]
+			},
+			want: `{
+  "contributors": [
+    {"name": "carol"},
+    // maintainer
+    {"name": "bob", "email": "bob@example.com", "github": "bob"},
+    {"name": "alice", "url": "https://alice.dev", "email": "alice@example.com"}
+  ]
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := jsonedit.Parse(strings.NewReader(r), &Contributors{}, jsonedit.WithDialect(jsonedit.JSONC))
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			doc.IdentityKey = tt.identityKey
+			tt.edit(doc.TypedData)
+
+			got, err := doc.String()
+			if err != nil {
+				t.Fatalf("String() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRootArrayElementPreservation(t *testing.T) {
+	r := `[{"name": "alice", "id": 1}, {"name": "bob", "id": 2}]`
+	doc, err := jsonedit.Parse[[]Person](strings.NewReader(r), nil)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	doc.IdentityKey = "name"
+	doc.TypedData = doc.TypedData[1:]
+
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if want := `[{"name": "bob", "id": 2}]`; got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+type Meta struct {
+	Version string `json:"version"`
+	License string `json:"license"`
+}
+
+type Repository struct {
+	URL string `json:"url"`
+}
+
+type Links struct {
+	URL string `json:"url"`
+}
+
+type EmbeddedPackage struct {
+	Meta
+	*Repository
+	Links
+	Name    string `json:"name"`
+	License string `json:"license,omitempty"`
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	r := `{"name": "app", "version": "1.0.0", "license": "MIT", "url": "https://example.com", "private": true}`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &EmbeddedPackage{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	if doc.TypedData.Version != "1.0.0" {
+		t.Errorf("Got %q want %q", doc.TypedData.Version, "1.0.0")
+	}
+	// The shallower License field shadows the promoted one
+	if doc.TypedData.License != "MIT" || doc.TypedData.Meta.License != "" {
+		t.Errorf("Got %q and %q want %q and %q", doc.TypedData.License, doc.TypedData.Meta.License, "MIT", "")
+	}
+	// Conflicting url fields at the same depth are ignored and kept as rest
+	if _, ok := doc.Rest.Get("url"); !ok {
+		t.Errorf("url is missing from rest")
+	}
+	for _, key := range []string{"name", "version", "license"} {
+		if _, ok := doc.Rest.Get(key); ok {
+			t.Errorf("Typed key %q is in rest", key)
+		}
+	}
+
+	doc.TypedData.Version = "1.1.0"
+	doc.TypedData.License = ""
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if want := `{"name": "app", "version": "1.1.0", "url": "https://example.com", "private": true}`; got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+type Deadline struct {
+	Day string
+}
+
+func (d Deadline) IsZero() bool {
+	return d.Day == "" || d.Day == "never"
+}
+
+type TaggedConfig struct {
+	Port     int      `json:"port,string"`
+	Debug    bool     `json:"debug,string,omitempty"`
+	Retries  int      `json:"retries,omitzero"`
+	Deadline Deadline `json:"deadline,omitzero"`
+	Name     string   `json:"name"`
+	internal string
+}
+
+func TestStructTags(t *testing.T) {
+	r := `{"port": "8080", "Name": "api", "retries": 3, "deadline": {"Day": "friday"}, "internal": "x"}`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &TaggedConfig{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	if doc.TypedData.Port != 8080 || doc.TypedData.Name != "api" {
+		t.Errorf("Got %d and %q want %d and %q", doc.TypedData.Port, doc.TypedData.Name, 8080, "api")
+	}
+	// Name is decoded case-insensitively, internal matches no exported field
+	if keys := strings.Join(doc.Rest.Keys, ","); keys != "internal" {
+		t.Errorf("Got %q want %q", keys, "internal")
+	}
+
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if got != r {
+		t.Errorf("Got %q want %q", got, r)
+	}
+
+	doc.TypedData.Port = 9090
+	doc.TypedData.Debug = true
+	doc.TypedData.Name = "web"
+	doc.TypedData.Retries = 0
+	doc.TypedData.Deadline.Day = "never"
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if want := `{"port": "9090", "debug": "true", "Name": "web", "internal": "x"}`; got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestCaseInsensitiveDuplicateKeys(t *testing.T) {
+	// encoding/json decodes the last matching key
+	r := `{"name": "first", "NAME": "second"}`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &TaggedConfig{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if doc.TypedData.Name != "second" {
+		t.Errorf("Got %q want %q", doc.TypedData.Name, "second")
+	}
+
+	doc.TypedData.Name = "third"
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if want := `{"name": "first", "port": "0", "NAME": "third"}`; got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		edit  func(doc *jsonedit.Document[*SimpleStruct])
+		want  string
+	}{
+		{
+			name:  "unchanged",
+			input: `{"foo":"a","foo":"b","bar":1,"Baz":true}`,
+			want:  `{"foo":"a","foo":"b","bar":1,"Baz":true}`,
+		},
+		{
+			name:  "unchanged apart",
+			input: `{"foo": "a", "x": {"y": 1, "y": 2}, "foo": "b", "bar": 1, "Baz": false}`,
+			want:  `{"foo": "a", "x": {"y": 1, "y": 2}, "foo": "b", "bar": 1, "Baz": false}`,
+		},
+		{
+			name:  "last value edited",
+			input: `{"foo":"a","bar":1,"foo":"b"}`,
+			edit:  func(doc *jsonedit.Document[*SimpleStruct]) { doc.TypedData.Foo = "c" },
+			want:  `{"foo":"a","bar":1,"foo":"c","Baz":false}`,
+		},
+		{
+			name:  "key deleted",
+			input: `{"x":1,"bar":1,"x":2}`,
+			edit:  func(doc *jsonedit.Document[*SimpleStruct]) { doc.Rest.Delete("x") },
+			want:  `{"foo":"","bar":1,"Baz":false}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := jsonedit.Parse(strings.NewReader(tt.input), &SimpleStruct{})
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			if tt.edit != nil {
+				tt.edit(doc)
+			}
+			got, err := doc.String()
+			if err != nil {
+				t.Fatalf("String() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type Level int
+
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte([]string{"debug", "info", "warn"}[l]), nil
+}
+
+func (l *Level) UnmarshalText(text []byte) error {
+	for i, name := range []string{"debug", "info", "warn"} {
+		if string(text) == name {
+			*l = Level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown level %q", text)
+}
+
+type Semver struct {
+	Major, Minor, Patch int
+}
+
+func (v Semver) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"major":%d,"minor":%d,"patch":%d}`, v.Major, v.Minor, v.Patch)), nil
+}
+
+func (v *Semver) UnmarshalJSON(data []byte) error {
+	var raw struct{ Major, Minor, Patch int }
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*v = Semver(raw)
+	return nil
+}
+
+type MarshalerConfig struct {
+	Updated  time.Time      `json:"updated"`
+	Level    Level          `json:"level"`
+	Version  Semver         `json:"version"`
+	Limits   map[Level]int  `json:"limits"`
+	Versions map[int]string `json:"versions"`
+}
+
+func TestMarshalers(t *testing.T) {
+	r := `{
+  "updated": "2024-01-02T03:04:05.000Z",
+  "level": "info",
+  "version": {"major": 1, "minor": 2, "patch": 3, "pre": "beta"},
+  "limits": {"warn": 10, "debug": 1000},
+  "versions": {"2": "two", "10": "ten"}
+}`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &MarshalerConfig{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if got != r {
+		t.Errorf("Got %q want %q", got, r)
+	}
+
+	doc.TypedData.Updated = time.Date(2025, 6, 7, 8, 9, 10, 0, time.UTC)
+	doc.TypedData.Level = 2
+	doc.TypedData.Version.Minor = 3
+	doc.TypedData.Limits[1] = 100
+	doc.TypedData.Versions[3] = "three"
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	// Semver has no field for pre, its output replaces the whole object
+	want := `{
+  "updated": "2025-06-07T08:09:10Z",
+  "level": "warn",
+  "version": {"major": 1, "minor": 3, "patch": 3},
+  "limits": {"warn": 10, "debug": 1000, "info": 100},
+  "versions": {"2": "two", "10": "ten", "3": "three"}
+}`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestMarshalerReindent(t *testing.T) {
+	r := `{
+  "name": "app"
+}`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &struct {
+		Name    string  `json:"name"`
+		Version *Semver `json:"version,omitempty"`
+	}{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	doc.TypedData.Version = &Semver{Major: 2}
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `{
+  "name": "app",
+  "version": {
+    "major": 2,
+    "minor": 0,
+    "patch": 0
+  }
+}`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+type DecodeEmbedded struct {
+	Inner string `json:"inner"`
+}
+
+type DecodeTarget struct {
+	*DecodeEmbedded
+	Any     interface{}     `json:"any"`
+	Count   int64           `json:"count,string"`
+	Flag    bool            `json:"flag,string"`
+	Pair    [2]int          `json:"pair"`
+	Small   int8            `json:"small"`
+	Number  json.Number     `json:"number"`
+	Bytes   []byte          `json:"bytes"`
+	Raw     json.RawMessage `json:"raw"`
+	Version Semver          `json:"version"`
+	Level   Level           `json:"level"`
+	Ptr     *float64        `json:"ptr"`
+	Map     map[string]int  `json:"map"`
+	Levels  map[Level]int   `json:"levels"`
+}
+
+// TestDecodeLikeEncodingJSON decodes the same input with Parse and
+// json.Unmarshal and compares the results
+func TestDecodeLikeEncodingJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		target func() interface{}
+	}{
+		{
+			name: "all kinds",
+			input: `{"inner": "x", "any": {"a": [1, "b", null, true, 1e400]}, "count": "42", "flag": "true",
+  "pair": [1, 2, 3], "small": 7, "number": 1.50, "bytes": "aGk=", "raw": {"k":  [1, 2]},
+  "version": {"Major": 1, "Minor": 2}, "level": "warn", "ptr": 2.5, "map": {"a": 1, "b": 2},
+  "levels": {"warn": 1, "error": 2}}`,
+			target: func() interface{} { return &DecodeTarget{} },
+		},
+		{
+			name:   "short array and raw scalar",
+			input:  `{"pair": [9], "raw": 1.0e2, "number": "12"}`,
+			target: func() interface{} { return &DecodeTarget{} },
+		},
+		{
+			name:   "nulls",
+			input:  `{"inner": null, "ptr": null, "raw": null, "bytes": null, "map": null, "version": null}`,
+			target: func() interface{} { return &DecodeTarget{} },
+		},
+		{
+			name:   "overflow",
+			input:  `{"small": 300, "count": "7"}`,
+			target: func() interface{} { return &DecodeTarget{} },
+		},
+		{
+			name:   "type mismatch",
+			input:  `{"small": "s", "map": {"a": "b", "c": 3}, "pair": {}, "inner": "z"}`,
+			target: func() interface{} { return &DecodeTarget{} },
+		},
+		{
+			name:   "invalid string option",
+			input:  `{"count": 5}`,
+			target: func() interface{} { return &DecodeTarget{} },
+		},
+		{
+			name:   "unmarshaler error",
+			input:  `{"level": "trace"}`,
+			target: func() interface{} { return &DecodeTarget{} },
+		},
+		{
+			name:   "interface root",
+			input:  `[1, {"a": 2.5}, "x"]`,
+			target: func() interface{} { return new(interface{}) },
+		},
+		{
+			name:   "raw root",
+			input:  ` {"a" : [ 1 ]} `,
+			target: func() interface{} { return &json.RawMessage{} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.target()
+			wantErr := json.Unmarshal([]byte(tt.input), want)
+
+			got := tt.target()
+			_, err := jsonedit.Parse(strings.NewReader(tt.input), got)
+			// Error types and messages differ between versions of
+			// encoding/json, only compare whether there is one
+			if (err == nil) != (wantErr == nil) {
+				t.Fatalf("Got error %v want %v", err, wantErr)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Got %#v want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestEditor(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  []jsonedit.Option
+		edit  func(e *jsonedit.Editor) error
+		want  string
+	}{
+		{
+			name:  "replace",
+			input: "{\n  \"name\": \"app\",\n  \"version\": \"1.0.0\"\n}\n",
+			edit:  func(e *jsonedit.Editor) error { return e.Set("/version", "1.1.0") },
+			want:  "{\n  \"name\": \"app\",\n  \"version\": \"1.1.0\"\n}\n",
+		},
+		{
+			name:  "delete first",
+			input: `{"a": 1, "b": 2, "c": 3}`,
+			edit:  func(e *jsonedit.Editor) error { return e.Delete("/a") },
+			want:  `{"b": 2, "c": 3}`,
+		},
+		{
+			name:  "delete middle",
+			input: "{\n  \"a\": 1,\n  \"b\": 2,\n  \"c\": 3\n}",
+			edit:  func(e *jsonedit.Editor) error { return e.Delete("/b") },
+			want:  "{\n  \"a\": 1,\n  \"c\": 3\n}",
+		},
+		{
+			name:  "delete last",
+			input: "{\n  \"a\": 1,\n  \"b\": 2\n}",
+			edit:  func(e *jsonedit.Editor) error { return e.Delete("/b") },
+			want:  "{\n  \"a\": 1\n}",
+		},
+		{
+			name:  "insert missing key",
+			input: "{\n  \"scripts\": {\n    \"build\": \"vite build\"\n  }\n}",
+			edit:  func(e *jsonedit.Editor) error { return e.Set("/scripts/test", "vitest") },
+			want:  "{\n  \"scripts\": {\n    \"build\": \"vite build\",\n    \"test\": \"vitest\"\n  }\n}",
+		},
+		{
+			name:  "insert missing parent",
+			input: `{"name": "app"}`,
+			edit:  func(e *jsonedit.Editor) error { return e.Set("/engines/node", ">=18") },
+			want:  `{"name": "app", "engines": {"node": ">=18"}}`,
+		},
+		{
+			name:  "insert with input spacing",
+			input: `{"a":1, "b":2}`,
+			edit:  func(e *jsonedit.Editor) error { return e.Set("/c/d", []int{1, 2}) },
+			want:  `{"a":1, "b":2, "c":{"d":[1, 2]}}`,
+		},
+		{
+			name:  "array",
+			input: `{"files": ["dist", "src", "test"]}`,
+			edit: func(e *jsonedit.Editor) error {
+				if err := e.Delete("/files/0"); err != nil {
+					return err
+				}
+				if err := e.Set("/files/2", "tests"); err != nil {
+					return err
+				}
+				return e.Set("/files/-", "docs")
+			},
+			want: `{"files": ["src", "tests", "docs"]}`,
+		},
+		{
+			name:  "escaped pointer",
+			input: `{"a/b": {"m~n": 1}}`,
+			edit:  func(e *jsonedit.Editor) error { return e.Set("/a~1b/m~0n", 2) },
+			want:  `{"a/b": {"m~n": 2}}`,
+		},
+		{
+			name:  "comments",
+			input: "{\n  // the name\n  \"name\": \"app\", // inline\n  \"version\": \"1.0.0\" /* last */\n}",
+			opts:  []jsonedit.Option{jsonedit.WithDialect(jsonedit.JSONC)},
+			edit: func(e *jsonedit.Editor) error {
+				if err := e.Delete("/version"); err != nil {
+					return err
+				}
+				return e.Set("/private", true)
+			},
+			want: "{\n  // the name\n  \"name\": \"app\", // inline\n  \"private\": true\n}",
+		},
+		{
+			name:  "trailing commas",
+			input: "{\n  \"a\": 1,\n  \"b\": 2,\n}",
+			opts:  []jsonedit.Option{jsonedit.WithTrailingCommas()},
+			edit:  func(e *jsonedit.Editor) error { return e.Delete("/b") },
+			want:  "{\n  \"a\": 1,\n}",
+		},
+		{
+			name:  "replace root",
+			input: "[1, 2]\n",
+			edit:  func(e *jsonedit.Editor) error { return e.Set("", []int{3}) },
+			want:  "[3]\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := jsonedit.NewEditor(tt.opts...)
+			if err := tt.edit(e); err != nil {
+				t.Fatalf("edit failed: %v", err)
+			}
+			var b strings.Builder
+			if err := e.Apply(&b, strings.NewReader(tt.input)); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("Got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditorErrors(t *testing.T) {
+	e := jsonedit.NewEditor()
+	if err := e.Set("/a/b", 1); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := e.Set("/a/b/c", 1); err == nil {
+		t.Error("Set() accepted an edit inside another edit")
+	}
+	if err := e.Delete("a"); err == nil {
+		t.Error("Delete() accepted a pointer without leading slash")
+	}
+
+	if err := e.Apply(io.Discard, strings.NewReader(`{"a": [1]}`)); err == nil {
+		t.Error("Apply() succeeded with a missing array element")
+	}
+	if err := e.Apply(io.Discard, strings.NewReader(`{"a": {"b": }}`)); err == nil {
+		t.Error("Apply() accepted invalid JSON")
+	}
+	if err := e.Apply(io.Discard, strings.NewReader(`{"a": {"b": 0}} x`)); err == nil {
+		t.Error("Apply() accepted data after the top-level value")
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `{"a": {"b": tru}}`, want: "invalid character '}' in literal true"},
+		{input: `{"a": 01}`, want: "invalid character '1' after object key:value pair"},
+		{input: `{"a": [1.5.2]}`, want: "invalid character '.' after array element"},
+		{input: `{"a": {"b": 1}, "c": truex}`, want: "invalid character 'x' after object key:value pair"},
+		{input: `01`, want: "invalid character '1' after top-level value"},
+	}
+	for _, tt := range tests {
+		err := jsonedit.NewEditor().Apply(io.Discard, strings.NewReader(tt.input))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want+" at ") {
+			t.Errorf("Got %v want an error starting with %q for %s", err, tt.want, tt.input)
+		}
+	}
+}
+
+func TestEditorDeleteComments(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		pointers []string
+	}{
+		{
+			name:     "first",
+			input:    "{\n  // about a\n  \"a\": 1,\n  \"b\": 2\n}",
+			pointers: []string{"/a"},
+		},
+		{
+			name:     "first with comments on the next",
+			input:    "{\n  // about a\n  \"a\": 1, // a\n  // about b\n  \"b\": 2,\n  \"c\": 3\n}",
+			pointers: []string{"/a"},
+		},
+		{
+			name:     "middle",
+			input:    "{\n  \"z\": 0,\n  \"m\": 1, // m\n  \"b\": 2\n}",
+			pointers: []string{"/m"},
+		},
+		{
+			name:     "middle with comments",
+			input:    "{\n  \"z\": 0, // z\n  // about m\n  \"m\": 1, // m\n  // about b\n  \"b\": 2\n}",
+			pointers: []string{"/m"},
+		},
+		{
+			name:     "consecutive",
+			input:    "{\n  \"z\": 0, // z\n  \"a\": 1, // a\n  \"b\": 2, // b\n  \"c\": 3\n}",
+			pointers: []string{"/a", "/b"},
+		},
+		{
+			name:     "single line",
+			input:    `{"a": 1, /* a */ "b": 2, "c": 3}`,
+			pointers: []string{"/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := jsonedit.Parse(strings.NewReader(tt.input), (*SimpleStruct)(nil), jsonedit.WithDialect(jsonedit.JSONC))
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			e := jsonedit.NewEditor(jsonedit.WithDialect(jsonedit.JSONC))
+			for _, pointer := range tt.pointers {
+				if err := doc.Delete(pointer); err != nil {
+					t.Fatalf("Document.Delete() failed: %v", err)
+				}
+				if err := e.Delete(pointer); err != nil {
+					t.Fatalf("Editor.Delete() failed: %v", err)
+				}
+			}
+			want, err := doc.String()
+			if err != nil {
+				t.Fatalf("String() failed: %v", err)
+			}
+
+			var b strings.Builder
+			if err := e.Apply(&b, strings.NewReader(tt.input)); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if got := b.String(); got != want {
+				t.Errorf("Got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		r      string
+		line   int
+		column int
+		path   string
+	}{
+		{
+			name:   "missing value",
+			r:      "{\n  \"name\": \"app\",\n  \"version\": \n}",
+			line:   4,
+			column: 1,
+			path:   "/version",
+		},
+		{
+			name:   "bad array element",
+			r:      "{\"files\": [\"dist\", tru]}",
+			line:   1,
+			column: 23,
+			path:   "/files/1",
+		},
+		{
+			name:   "missing comma",
+			r:      "{\n  \"a\": 1\n  \"b\": 2\n}",
+			line:   3,
+			column: 3,
+			path:   "",
+		},
+		{
+			name:   "unexpected end",
+			r:      "{\"a\": [1, 2",
+			line:   1,
+			column: 12,
+			path:   "/a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, apply := range []func() error{
+				func() error {
+					_, err := jsonedit.Parse(strings.NewReader(tt.r), (*SimpleStruct)(nil))
+					return err
+				},
+				func() error {
+					return jsonedit.NewEditor().Apply(io.Discard, strings.NewReader(tt.r))
+				},
+			} {
+				var se *jsonedit.SyntaxError
+				if err := apply(); !errors.As(err, &se) {
+					t.Fatalf("Got error %v want *SyntaxError", err)
+				}
+				if se.Line != tt.line || se.Column != tt.column || se.Path != tt.path {
+					t.Errorf("Got %d:%d at %q want %d:%d at %q", se.Line, se.Column, se.Path, tt.line, tt.column, tt.path)
+				}
+			}
+		})
+	}
+}
+
+func TestTypeError(t *testing.T) {
+	r := "{\n  \"contributors\": [\n    {\"name\": \"alice\"},\n    {\"name\": 42}\n  ]\n}"
+	_, err := jsonedit.Parse(strings.NewReader(r), &Contributors{})
+
+	var te *jsonedit.TypeError
+	if !errors.As(err, &te) {
+		t.Fatalf("Got error %v want *TypeError", err)
+	}
+	if te.Path != "/contributors/1/name" || te.Line != 4 || te.Column != 14 {
+		t.Errorf("Got %d:%d at %q want 4:14 at %q", te.Line, te.Column, te.Path, "/contributors/1/name")
+	}
+
+	var ute *json.UnmarshalTypeError
+	if !errors.As(err, &ute) {
+		t.Fatalf("Got error %v want it to wrap *json.UnmarshalTypeError", err)
+	}
+	if ute.Field != "contributors.1.name" {
+		t.Errorf("Got %q want %q", ute.Field, "contributors.1.name")
+	}
+
+	// Values stored by Set are reported at the pointer without a position
+	doc, err := jsonedit.Parse(strings.NewReader(`{"contributors": [{"name": "alice"}]}`), &Contributors{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	tests := []struct {
+		pointer string
+		value   interface{}
+		want    string
+	}{
+		{
+			pointer: "/contributors/0/name",
+			value:   42,
+			want:    `jsonedit: cannot decode number into Go value of type string at "/contributors/0/name"`,
+		},
+		{
+			pointer: "/contributors/0",
+			value:   map[string]interface{}{"name": true},
+			want:    `jsonedit: cannot decode bool into Go value of type string at "/contributors/0/name"`,
+		},
+	}
+	for _, tt := range tests {
+		err := doc.Set(tt.pointer, tt.value)
+		if !errors.As(err, &te) || te.Line != 0 {
+			t.Fatalf("Got error %v want *TypeError without position", err)
+		}
+		if got := err.Error(); got != tt.want {
+			t.Errorf("Got %q want %q", got, tt.want)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	r := "{\n  \"name\": \"app\",\n  \"dependencies\": {\n    \"react\": \"^18.2.0\"\n  },\n  \"files\": [\"dist\", \"src\"]\n}\n"
+	doc, err := jsonedit.Parse(strings.NewReader(r), &PackageJson{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	tests := []struct {
+		pointer string
+		key     string
+		value   string
+	}{
+		{pointer: "/name", key: "2:3-2:9", value: "2:11-2:16"},
+		{pointer: "/dependencies", key: "3:3-3:17", value: "3:19-5:4"},
+		{pointer: "/dependencies/react", key: "4:5-4:12", value: "4:14-4:23"},
+		{pointer: "/files/1", key: "0:0-0:0", value: "6:21-6:26"},
+		{pointer: "", key: "0:0-0:0", value: "1:1-7:2"},
+	}
+	for _, tt := range tests {
+		loc, ok := doc.Locate(tt.pointer)
+		if !ok {
+			t.Errorf("Locate(%q) found nothing", tt.pointer)
+			continue
+		}
+		key := loc.Key.Start.String() + "-" + loc.Key.End.String()
+		value := loc.Value.Start.String() + "-" + loc.Value.End.String()
+		if key != tt.key || value != tt.value {
+			t.Errorf("Got %s %s want %s %s for %q", key, value, tt.key, tt.value, tt.pointer)
+		}
+		if got := r[loc.Value.Start.Offset:loc.Value.End.Offset]; tt.pointer == "/files/1" && got != `"src"` {
+			t.Errorf("Got %q want %q", got, `"src"`)
+		}
+	}
+
+	for _, pointer := range []string{"/version", "/files/2", "/files/01", "/name/0", "name"} {
+		if _, ok := doc.Locate(pointer); ok {
+			t.Errorf("Locate(%q) found a value", pointer)
+		}
+	}
+
+	locs := doc.Locations()
+	if len(locs) != 7 {
+		t.Errorf("Got %d locations want 7", len(locs))
+	}
+	for pointer, loc := range locs {
+		if want, _ := doc.Locate(pointer); loc != want {
+			t.Errorf("Got %v want %v for %q", loc, want, pointer)
+		}
+	}
+}
+
+type PointerPackage struct {
+	Name         string            `json:"name"`
+	Private      bool              `json:"private,omitempty"`
+	Repository   *Repository       `json:"repository,omitempty"`
+	Dependencies map[string]string `json:"dependencies"`
+	Contributors []Person          `json:"contributors"`
+}
+
+func TestPointerAccess(t *testing.T) {
+	r := `{
+  "name": "app",
+  "repository": {"type": "git", "url": "https://example.com/app.git"},
+  "dependencies": {"react": "^18.2.0"},
+  "contributors": [{"name": "alice", "twitter": "@alice"}],
+  "scripts": {"build": "vite build", "lint": "eslint ."}
+}
+`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &PointerPackage{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	gets := map[string]interface{}{
+		"/name":                   "app",
+		"/repository/type":        "git",
+		"/dependencies/react":     "^18.2.0",
+		"/contributors/0/twitter": "@alice",
+		"/scripts/lint":           "eslint .",
+	}
+	for pointer, want := range gets {
+		if got, ok := doc.Get(pointer); !ok || got != want {
+			t.Errorf("Got %v want %v for %q", got, want, pointer)
+		}
+	}
+	for _, pointer := range []string{"/private", "/version", "/scripts/test", "/contributors/1", "/name/0"} {
+		if doc.Has(pointer) {
+			t.Errorf("Has(%q) reported a value", pointer)
+		}
+	}
+
+	edits := []struct {
+		pointer string
+		value   interface{}
+		delete  bool
+	}{
+		{pointer: "/dependencies/zod", value: "^3.22.0"},
+		{pointer: "/repository/directory", value: "packages/app"},
+		{pointer: "/contributors/-", value: map[string]string{"name": "bob"}},
+		{pointer: "/contributors/0/twitter", delete: true},
+		{pointer: "/scripts/build", delete: true},
+		{pointer: "/scripts/test", value: "vitest"},
+		{pointer: "/engines/node", value: ">=18"},
+		{pointer: "/private", value: true},
+	}
+	for _, e := range edits {
+		if e.delete {
+			err = doc.Delete(e.pointer)
+		} else {
+			err = doc.Set(e.pointer, e.value)
+		}
+		if err != nil {
+			t.Fatalf("edit of %q failed: %v", e.pointer, err)
+		}
+	}
+
+	if got := doc.TypedData.Contributors[1].Name; got != "bob" {
+		t.Errorf("Got %q want %q", got, "bob")
+	}
+	// The original values stay as they were parsed
+	for key, want := range map[string]string{"repository": "type,url", "scripts": "build,lint"} {
+		v, _ := doc.OriginalMap.Get(key)
+		if got := strings.Join(v.(*jsonedit.OrderedMap).Keys, ","); got != want {
+			t.Errorf("Got %q want %q for original %q", got, want, key)
+		}
+	}
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `{
+  "name": "app",
+  "private": true,
+  "repository": {"type": "git", "url": "https://example.com/app.git", "directory": "packages/app"},
+  "dependencies": {"react": "^18.2.0", "zod": "^3.22.0"},
+  "contributors": [{"name": "alice"}, {"name": "bob"}],
+  "scripts": {"lint": "eslint .", "test": "vitest"},
+  "engines": {
+    "node": ">=18"
+  }
+}
+`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	if err := doc.Set("/name/first", "x"); err == nil {
+		t.Error("Set() succeeded below a string")
+	}
+	if err := doc.Delete("/scripts/build"); err == nil {
+		t.Error("Delete() succeeded for a missing key")
+	}
+	// name has no omitempty, zeroing it would not remove it
+	if err := doc.Delete("/name"); err == nil || !doc.Has("/name") {
+		t.Errorf("Delete() of a field that is always written returned %v", err)
+	}
+	if got, err := doc.String(); err != nil || got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestEditOriginalMap(t *testing.T) {
+	doc, err := jsonedit.Parse(strings.NewReader(`{"a": 1, "b": {"c": 2}}`), (*PackageJson)(nil))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	doc.OriginalMap.Set("a", 99.0, 0)
+	b, _ := doc.OriginalMap.Get("b")
+	b.(*jsonedit.OrderedMap).Set("d", 3.0, 1)
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if want := `{"a": 99, "b": {"c": 2, "d": 3}}`; got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	// Edits through pointers keep the edited OriginalMap as it is
+	if err := doc.Set("/b/e", 4); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	got, err = doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if want := `{"a": 99, "b": {"c": 2, "d": 3, "e": 4}}`; got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+	if got := strings.Join(b.(*jsonedit.OrderedMap).Keys, ","); got != "c,d" {
+		t.Errorf("Got original keys %q want %q", got, "c,d")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	r := `{
+  "name": "monorepo",
+  "version": "1.0.0",
+  "workspaces": [
+    {"name": "app", "version": "0.3.0", "private": true},
+    {"name": "lib", "version": "2.1.0", "tags": ["core", "util"]},
+    {"name": "docs", "version": "0.1.0"}
+  ]
+}
+`
+	doc, err := jsonedit.Parse(strings.NewReader(r), (*PackageJson)(nil))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "$.workspaces[*].name", want: "$['workspaces'][0]['name'] $['workspaces'][1]['name'] $['workspaces'][2]['name']"},
+		{query: "$..version", want: "$['version'] $['workspaces'][0]['version'] $['workspaces'][1]['version'] $['workspaces'][2]['version']"},
+		{query: "$.workspaces[-1:]", want: "$['workspaces'][2]"},
+		{query: "$.workspaces[::-2].name", want: "$['workspaces'][2]['name'] $['workspaces'][0]['name']"},
+		{query: "$.workspaces[?@.private].name", want: "$['workspaces'][0]['name']"},
+		{query: "$.workspaces[?@.version >= '1' && !@.private]['name', 'version']", want: "$['workspaces'][1]['name'] $['workspaces'][1]['version']"},
+		{query: `$.workspaces[?match(@.name, "[a-l].*") && count(@.*) < 3].name`, want: "$['workspaces'][2]['name']"},
+		{query: "$.workspaces[?length(@.tags) == 2].tags[1]", want: "$['workspaces'][1]['tags'][1]"},
+		{query: "$.missing[0]", want: ""},
+	}
+	for _, tt := range tests {
+		matches, err := doc.Query(tt.query)
+		if err != nil {
+			t.Errorf("Query(%q) failed: %v", tt.query, err)
+			continue
+		}
+		var paths []string
+		for _, m := range matches {
+			paths = append(paths, m.Path)
+		}
+		if got := strings.Join(paths, " "); got != tt.want {
+			t.Errorf("Got %q want %q for %q", got, tt.want, tt.query)
+		}
+	}
+
+	for _, query := range []string{"workspaces", "$.", "$[01]", "$[?@.* == 1]", "$[?count(@)]", "$[?length(@) == 1", "$ "} {
+		if _, err := jsonedit.CompileJSONPath(query); err == nil {
+			t.Errorf("CompileJSONPath(%q) accepted an invalid query", query)
+		}
+	}
+}
+
+func TestUpdateAll(t *testing.T) {
+	r := `{
+  "dependencies": {
+    "react": "^18.2.0", // UI
+    "zod": "^3.21.4"
+  },
+  "devDependencies": {"vite": "^5.0.0"},
+  "overrides": [{"name": "react", "version": "^18.2.0"}]
+}
+`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &PackageJson{}, jsonedit.WithDialect(jsonedit.JSONC))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	err = doc.UpdateAll(`$..[?@ == '^18.2.0']`, func(m jsonedit.Match) (interface{}, error) {
+		return "^18.3.1", nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateAll() failed: %v", err)
+	}
+	err = doc.UpdateAll(`$.devDependencies.*`, func(m jsonedit.Match) (interface{}, error) {
+		return strings.Replace(m.Value.(string), "^", "~", 1), nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateAll() failed: %v", err)
+	}
+
+	if got := doc.TypedData.Dependencies["react"]; got != "^18.3.1" {
+		t.Errorf("Got %q want %q", got, "^18.3.1")
+	}
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `{
+  "dependencies": {
+    "react": "^18.3.1", // UI
+    "zod": "^3.21.4"
+  },
+  "devDependencies": {"vite": "~5.0.0"},
+  "overrides": [{"name": "react", "version": "^18.3.1"}]
+}
+`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	r := `{
+  "name": "app",
+  "dependencies": {"react": "^18.2.0", "zod": "^3.22.0"},
+  "contributors": [{"name": "alice"}, {"name": "carol"}],
+  "scripts": {"build": "vite build", "lint": "eslint ."}
+}
+`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &PointerPackage{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	// Inserted contributors shift the others, match them by name
+	doc.IdentityKey = "name"
+
+	patch := `[
+  {"op": "test", "path": "/dependencies/react", "value": "^18.2.0"},
+  {"op": "replace", "path": "/dependencies/react", "value": "^18.3.1"},
+  {"op": "add", "path": "/contributors/1", "value": {"name": "bob"}},
+  {"op": "remove", "path": "/dependencies/zod"},
+  {"op": "move", "from": "/scripts/lint", "path": "/scripts/check"},
+  {"op": "copy", "from": "/scripts/build", "path": "/scripts/prepare"},
+  {"op": "add", "path": "/private", "value": true},
+  {"op": "test", "path": "/contributors", "value": [{"name": "alice"}, {"name": "bob"}, {"name": "carol"}]}
+]`
+	if err := doc.ApplyPatch(strings.NewReader(patch)); err != nil {
+		t.Fatalf("ApplyPatch() failed: %v", err)
+	}
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `{
+  "name": "app",
+  "private": true,
+  "dependencies": {"react": "^18.3.1"},
+  "contributors": [{"name": "alice"}, {"name": "bob"}, {"name": "carol"}],
+  "scripts": {"build": "vite build", "check": "eslint .", "prepare": "vite build"}
+}
+`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	// Failing patches leave the document as it was
+	failing := []string{
+		`[{"op": "remove", "path": "/scripts/build"}, {"op": "test", "path": "/name", "value": "api"}]`,
+		`[{"op": "add", "path": "/contributors/0", "value": {"name": "dave"}}, {"op": "replace", "path": "/version", "value": "1.0.0"}]`,
+		`[{"op": "replace", "path": "/name", "value": "api"}, {"op": "add", "path": "/engines/node", "value": ">=18"}]`,
+		`[{"op": "remove", "path": "/dependencies/react"}, {"op": "add", "path": "/contributors/9", "value": {}}]`,
+		`[{"op": "move", "from": "/scripts", "path": "/scripts/old"}]`,
+		`[{"op": "add", "path": "/title", "value": "x"}, {"op": "remove", "path": "/name"}]`,
+		`[{"op": "move", "from": "/name", "path": "/title"}]`,
+		`[{"op": "add", "path": "/private", "value": "yes"}]`,
+		`[{"op": "copy", "path": "/name"}]`,
+		`{"op": "remove", "path": "/name"}`,
+	}
+	for _, patch := range failing {
+		if err := doc.ApplyPatch(strings.NewReader(patch)); err == nil {
+			t.Errorf("ApplyPatch(%s) succeeded", patch)
+		}
+		if got, err := doc.String(); err != nil || got != want {
+			t.Errorf("Got %q want %q after ApplyPatch(%s)", got, want, patch)
+		}
+	}
+	if got := doc.TypedData.Contributors[0].Name; got != "alice" {
+		t.Errorf("Got %q want %q", got, "alice")
+	}
+
+	var pe *jsonedit.PatchError
+	err = doc.ApplyPatch(strings.NewReader(failing[0]))
+	if !errors.As(err, &pe) || pe.Index != 1 || pe.Op != "test" {
+		t.Errorf("Got %v want PatchError for operation 1", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	r := `{
+  "name": "app",
+  "repository": {"type": "git", "url": "https://example.com/app.git"},
+  "dependencies": {"react": "^18.2.0", "zod": "^3.22.0"},
+  "contributors": [{"name": "alice"}, {"name": "carol"}],
+  "scripts": {"build": "vite build"}
+}
+`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &PointerPackage{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if ops, err := doc.Diff(); err != nil || len(ops) != 0 {
+		t.Errorf("Got %v, %v want no operations", ops, err)
+	}
+
+	doc.TypedData.Name = "web"
+	doc.TypedData.Repository = nil
+	doc.TypedData.Dependencies["react"] = "^18.3.1"
+	delete(doc.TypedData.Dependencies, "zod")
+	doc.TypedData.Contributors = append(doc.TypedData.Contributors[:1:1], Person{Name: "bob"}, Person{Name: "carol"})
+	if err := doc.Set("/scripts/test", "vitest"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	ops, err := doc.Diff()
+	if err != nil {
+		t.Fatalf("Diff() failed: %v", err)
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	want := `[{"op":"replace","path":"/name","value":"web"},` +
+		`{"op":"remove","path":"/repository"},` +
+		`{"op":"replace","path":"/dependencies/react","value":"^18.3.1"},` +
+		`{"op":"remove","path":"/dependencies/zod"},` +
+		`{"op":"add","path":"/contributors/1","value":{"name":"bob"}},` +
+		`{"op":"add","path":"/scripts/test","value":"vitest"}]`
+	if string(patch) != want {
+		t.Errorf("Got %q want %q", patch, want)
+	}
+
+	// Applying the diff to the original gives the same document
+	orig, err := jsonedit.Parse(strings.NewReader(r), &PointerPackage{})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if err := orig.ApplyPatch(strings.NewReader(string(patch))); err != nil {
+		t.Fatalf("ApplyPatch() failed: %v", err)
+	}
+	got, err := orig.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	edited, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	if got != edited {
+		t.Errorf("Got %q want %q", got, edited)
+	}
+
+	// Edits of untyped documents do not change the original either
+	untyped, err := jsonedit.Parse(strings.NewReader(`{"x": {"y": 1}}`), (*struct{})(nil))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if err := untyped.Set("/x/z", 2); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	ops, err = untyped.Diff()
+	if err != nil {
+		t.Fatalf("Diff() failed: %v", err)
+	}
+	if len(ops) != 1 || ops[0].Op != "add" || ops[0].Path != "/x/z" {
+		t.Errorf("Got %+v want an add of /x/z", ops)
+	}
+}
+
+type AppSettings struct {
+	AllowedHosts string            `json:"AllowedHosts"`
+	LogLevel     map[string]string `json:"LogLevel"`
+	Port         int               `json:"Port"`
+}
+
+func TestMergePatch(t *testing.T) {
+	r := `{
+  // Base settings
+  "AllowedHosts": "*",
+  "LogLevel": {"Default": "Information", "Microsoft": "Warning"},
+  "Port": 8080,
+  "Kestrel": {
+    "Limits": {"MaxRequestBodySize": 1024},
+    "Http2": true
+  },
+  "Features": ["a", "b"]
+}
+`
+	doc, err := jsonedit.Parse(strings.NewReader(r), &AppSettings{}, jsonedit.WithDialect(jsonedit.JSONC))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	patch := `{
+  "Port": 443,
+  "Kestrel": {"Limits": {"MaxConcurrentConnections": 100, "MaxRequestBodySize": null}, "Http2": null, "Certificate": {"Path": "cert.pem", "Password": null}},
+  "LogLevel": {"Default": "Warning"},
+  "Features": ["c"],
+  "Zone": "eu",
+  "Region": "west",
+  "Missing": null
+}`
+	if err := doc.MergePatch(strings.NewReader(patch)); err != nil {
+		t.Fatalf("MergePatch() failed: %v", err)
+	}
+	if got := doc.TypedData.Port; got != 443 {
+		t.Errorf("Got %d want %d", got, 443)
+	}
+	got, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	want := `{
+  // Base settings
+  "AllowedHosts": "*",
+  "LogLevel": {"Default": "Warning", "Microsoft": "Warning"},
+  "Port": 443,
+  "Kestrel": {
+    "Limits": {"MaxConcurrentConnections": 100},
+    "Certificate": {
+      "Path": "cert.pem"
+    }
+  },
+  "Features": ["c"],
+  "Zone": "eu",
+  "Region": "west"
+}
+`
+	if got != want {
+		t.Errorf("Got %q want %q", got, want)
+	}
+
+	// Patches that cannot be applied leave the document unchanged
+	failing := []string{
+		`{"Zone": null, "Port": "https"}`,
+		// Port is always written, it cannot be removed
+		`{"Zone": null, "Port": null}`,
+	}
+	for _, patch := range failing {
+		if err := doc.MergePatch(strings.NewReader(patch)); err == nil {
+			t.Errorf("MergePatch(%s) succeeded", patch)
+		}
+		if got, err := doc.String(); err != nil || got != want {
+			t.Errorf("Got %q want %q", got, want)
+		}
+	}
+}
+
+// failingWriter fails the write that exceeds n bytes. Later writes succeed
+// again, so an error is only reported if the first one is kept.
+type failingWriter struct {
+	n      int
+	failed bool
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if !fw.failed && len(p) > fw.n {
+		fw.failed = true
+		return fw.n, errWriteFailed
+	}
+	fw.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteErrors(t *testing.T) {
+	r := "// config\n{\n  \"name\": \"app\",\n  \"dependencies\": {\"react\": \"^18.2.0\"},\n  \"files\": [\"dist\"]\n}\n"
+	doc, err := jsonedit.Parse(strings.NewReader(r), &PackageJson{}, jsonedit.WithDialect(jsonedit.JSONC))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	doc.TypedData.SetDependency("zod", "^3.22.0")
+	doc.Rest.Set("private", true, 10)
+
+	want, err := doc.String()
+	if err != nil {
+		t.Fatalf("String() failed: %v", err)
+	}
+	for n := 0; n < len(want); n++ {
+		if err := doc.Write(&failingWriter{n: n}); !errors.Is(err, errWriteFailed) {
+			t.Errorf("Write() after %d bytes returned %v, want %v", n, err, errWriteFailed)
+		}
+	}
+
+	e := jsonedit.NewEditor(jsonedit.WithDialect(jsonedit.JSONC))
+	if err := e.Set("/dependencies/zod", "^3.22.0"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := e.Apply(&failingWriter{n: 10}, strings.NewReader(r)); !errors.Is(err, errWriteFailed) {
+		t.Errorf("Apply() returned %v, want %v", err, errWriteFailed)
+	}
+}
+
+func TestWriteUnsupportedFloat(t *testing.T) {
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		doc, err := jsonedit.Parse(strings.NewReader(`{"ratio": 0.5, "name": "x"}`), &Json5Config{})
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+		doc.TypedData.Ratio = f
+
+		var ue *json.UnsupportedValueError
+		if err := doc.Write(io.Discard); !errors.As(err, &ue) {
+			t.Errorf("Write() with %v returned %v, want a *json.UnsupportedValueError", f, err)
+		}
+		if _, err := doc.String(); !errors.As(err, &ue) {
+			t.Errorf("String() with %v returned %v, want a *json.UnsupportedValueError", f, err)
+		}
+	}
+}
+
+const benchManifest = `{
+  "name": "web",
+  "version": "1.4.2",
+  "private": true,
+  "scripts": {"build": "vite build", "test": "vitest", "lint": "eslint ."},
+  "dependencies": {"axios": "^1.6.0", "react": "^18.2.0", "react-dom": "^18.2.0", "zod": "^3.22.0"},
+  "devDependencies": {"eslint": "^8.56.0", "typescript": "^5.3.0", "vite": "^5.0.0"},
+  "contributors": [
+    {"name": "alice", "email": "alice@example.com", "url": "https://alice.dev"},
+    {"name": "bob", "email": "bob@example.com"}
+  ],
+  "engines": {"node": ">=18"}
+}
+`
+
+type BenchManifest struct {
+	Name            string            `json:"name"`
+	Version         string            `json:"version"`
+	Private         bool              `json:"private,omitempty"`
+	Scripts         map[string]string `json:"scripts"`
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+	Contributors    []Person          `json:"contributors"`
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := jsonedit.Parse(strings.NewReader(benchManifest), &BenchManifest{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	doc, err := jsonedit.Parse(strings.NewReader(benchManifest), &BenchManifest{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	doc.TypedData.Dependencies["lodash"] = "^4.17.21"
+	doc.TypedData.Contributors[1].Email = "bob@example.org"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := doc.Write(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteParallel(b *testing.B) {
+	doc, err := jsonedit.Parse(strings.NewReader(benchManifest), &BenchManifest{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := doc.String(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
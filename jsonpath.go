@@ -0,0 +1,1205 @@
+package jsonedit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// JSONPath is a compiled JSONPath query as defined by RFC 9535, including
+// filters and the functions length, count, match, search and value
+type JSONPath struct {
+	query *filterQuery
+}
+
+// Match is a value selected by a JSONPath query. Path is its normalized path
+// like $['workspaces'][0], Pointer the same location as JSON Pointer.
+type Match struct {
+	Path    string
+	Pointer string
+	Value   interface{}
+}
+
+// CompileJSONPath parses a JSONPath query
+func CompileJSONPath(expr string) (*JSONPath, error) {
+	p := &pathParser{expr: expr}
+	if p.peek() != '$' {
+		return nil, p.errorf("query must start with $")
+	}
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.expr) {
+		return nil, p.errorf("unexpected %q", p.expr[p.pos])
+	}
+	return &JSONPath{query: q}, nil
+}
+
+// Select returns the values the query selects in root, a tree of
+// *OrderedMap, []interface{}, map[string]interface{} and scalars like the
+// values Parse produces. Matches are in document order.
+func (jp *JSONPath) Select(root interface{}) []Match {
+	nodes := jp.query.nodes(root, root)
+	matches := make([]Match, len(nodes))
+	for i, n := range nodes {
+		matches[i] = Match{
+			Path:    n.step.normalized(),
+			Pointer: formatPointer(n.step.tokens()),
+			Value:   n.value,
+		}
+	}
+	return matches
+}
+
+// Query selects values of the document with a JSONPath query. Values are
+// taken from the document as it would be written, including typed data.
+func (d *Document[T]) Query(expr string) ([]Match, error) {
+	jp, err := CompileJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	root, err := d.generic()
+	if err != nil {
+		return nil, err
+	}
+	return jp.Select(root), nil
+}
+
+// UpdateAll replaces every value selected by the JSONPath query with the
+// result of fn, like Set does. fn is called in document order and sees the
+// values as they were before the first update. When a match contains another
+// one, the outer update wins.
+func (d *Document[T]) UpdateAll(expr string, fn func(m Match) (interface{}, error)) error {
+	matches, err := d.Query(expr)
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(matches))
+	for i, m := range matches {
+		if values[i], err = fn(m); err != nil {
+			return err
+		}
+	}
+
+	// Descendants come after their ancestors, update them first so that
+	// their pointers are still valid
+	for i := len(matches) - 1; i >= 0; i-- {
+		if err := d.Set(matches[i].Pointer, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generic returns the document as it would be written as a tree of
+// *OrderedMap, []interface{} and scalars
+func (d *Document[T]) generic() (interface{}, error) {
+	var buf bytes.Buffer
+	if err := d.Write(&buf); err != nil {
+		return nil, err
+	}
+	// The output is valid JSON5 in every dialect
+	_, value, err := parseTree(buf.Bytes(), options{dialect: JSON5})
+	return value, err
+}
+
+// pathStep is the last step of the path to a selected value. Steps are
+// shared between the values below the same parent.
+type pathStep struct {
+	parent *pathStep
+	name   string
+	// index is the array index or -1 for object members
+	index int
+}
+
+func (s *pathStep) child(name string, index int) *pathStep {
+	return &pathStep{parent: s, name: name, index: index}
+}
+
+func (s *pathStep) tokens() []string {
+	var tokens []string
+	for ; s != nil; s = s.parent {
+		token := s.name
+		if s.index >= 0 {
+			token = strconv.Itoa(s.index)
+		}
+		tokens = append(tokens, token)
+	}
+	for i, j := 0, len(tokens)-1; i < j; i, j = i+1, j-1 {
+		tokens[i], tokens[j] = tokens[j], tokens[i]
+	}
+	return tokens
+}
+
+// normalized returns the normalized path (RFC 9535, section 2.7)
+func (s *pathStep) normalized() string {
+	var steps []*pathStep
+	for ; s != nil; s = s.parent {
+		steps = append(steps, s)
+	}
+
+	var b strings.Builder
+	b.WriteByte('$')
+	for i := len(steps) - 1; i >= 0; i-- {
+		if steps[i].index >= 0 {
+			fmt.Fprintf(&b, "[%d]", steps[i].index)
+			continue
+		}
+		b.WriteString("['")
+		for _, r := range steps[i].name {
+			switch r {
+			case '\b':
+				b.WriteString(`\b`)
+			case '\f':
+				b.WriteString(`\f`)
+			case '\n':
+				b.WriteString(`\n`)
+			case '\r':
+				b.WriteString(`\r`)
+			case '\t':
+				b.WriteString(`\t`)
+			case '\'':
+				b.WriteString(`\'`)
+			case '\\':
+				b.WriteString(`\\`)
+			default:
+				if r < 0x20 {
+					fmt.Fprintf(&b, `\u%04x`, r)
+				} else {
+					b.WriteRune(r)
+				}
+			}
+		}
+		b.WriteString("']")
+	}
+	return b.String()
+}
+
+// pathNode is a value selected by a query together with its location
+type pathNode struct {
+	value interface{}
+	step  *pathStep
+}
+
+// eachChild calls fn for the members of an object or the elements of an
+// array in document order
+func eachChild(n pathNode, fn func(child pathNode)) {
+	switch v := n.value.(type) {
+	case *OrderedMap:
+		for _, key := range v.Keys {
+			fn(pathNode{value: v.Values[key].Value, step: n.step.child(key, -1)})
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fn(pathNode{value: v[key], step: n.step.child(key, -1)})
+		}
+	case []interface{}:
+		for i, elem := range v {
+			fn(pathNode{value: elem, step: n.step.child("", i)})
+		}
+	}
+}
+
+// descendants calls fn for n and everything below it, parents first
+func descendants(n pathNode, fn func(n pathNode)) {
+	fn(n)
+	eachChild(n, func(child pathNode) {
+		descendants(child, fn)
+	})
+}
+
+// selectorKind tells what a selector selects
+type selectorKind int
+
+const (
+	selectName selectorKind = iota
+	selectWildcard
+	selectIndex
+	selectSlice
+	selectFilter
+)
+
+type selector struct {
+	kind selectorKind
+	name string
+	// index is the index of index selectors and the start of slices
+	index int
+	end   int
+	step  int
+	// Slices may leave out start and end
+	hasStart, hasEnd bool
+	filter           logicalExpr
+}
+
+// segment applies its selectors to the input nodes, or to the input nodes
+// and all their descendants
+type segment struct {
+	descendant bool
+	selectors  []selector
+}
+
+// filterQuery is a query relative to the root ($) or the current node (@)
+type filterQuery struct {
+	relative bool
+	segments []segment
+}
+
+func (q *filterQuery) nodes(root, current interface{}) []pathNode {
+	start := root
+	if q.relative {
+		start = current
+	}
+	nodes := []pathNode{{value: start}}
+	for _, seg := range q.segments {
+		var next []pathNode
+		for _, n := range nodes {
+			if !seg.descendant {
+				next = seg.apply(n, root, next)
+				continue
+			}
+			descendants(n, func(d pathNode) {
+				next = seg.apply(d, root, next)
+			})
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// singular reports whether the query selects at most one node
+func (q *filterQuery) singular() bool {
+	for _, seg := range q.segments {
+		if seg.descendant || len(seg.selectors) != 1 {
+			return false
+		}
+		if k := seg.selectors[0].kind; k != selectName && k != selectIndex {
+			return false
+		}
+	}
+	return true
+}
+
+// eval returns the value of a singular query
+func (q *filterQuery) eval(root, current interface{}) (interface{}, bool) {
+	nodes := q.nodes(root, current)
+	if len(nodes) != 1 {
+		return nil, false
+	}
+	return nodes[0].value, true
+}
+
+// test reports whether the query selects anything
+func (q *filterQuery) test(root, current interface{}) bool {
+	return len(q.nodes(root, current)) > 0
+}
+
+func (seg *segment) apply(n pathNode, root interface{}, out []pathNode) []pathNode {
+	for i := range seg.selectors {
+		out = seg.selectors[i].apply(n, root, out)
+	}
+	return out
+}
+
+func (s *selector) apply(n pathNode, root interface{}, out []pathNode) []pathNode {
+	switch s.kind {
+	case selectName:
+		var value interface{}
+		var ok bool
+		switch v := n.value.(type) {
+		case *OrderedMap:
+			value, ok = v.Get(s.name)
+		case map[string]interface{}:
+			value, ok = v[s.name]
+		}
+		if ok {
+			out = append(out, pathNode{value: value, step: n.step.child(s.name, -1)})
+		}
+	case selectWildcard:
+		eachChild(n, func(child pathNode) {
+			out = append(out, child)
+		})
+	case selectIndex:
+		arr, ok := n.value.([]interface{})
+		if !ok {
+			break
+		}
+		i := s.index
+		if i < 0 {
+			i += len(arr)
+		}
+		if i >= 0 && i < len(arr) {
+			out = append(out, pathNode{value: arr[i], step: n.step.child("", i)})
+		}
+	case selectSlice:
+		arr, ok := n.value.([]interface{})
+		if !ok {
+			break
+		}
+		lower, upper := s.bounds(len(arr))
+		switch {
+		case s.step > 0:
+			for i := lower; i < upper; i += s.step {
+				out = append(out, pathNode{value: arr[i], step: n.step.child("", i)})
+			}
+		case s.step < 0:
+			for i := upper; lower < i; i += s.step {
+				out = append(out, pathNode{value: arr[i], step: n.step.child("", i)})
+			}
+		}
+	case selectFilter:
+		eachChild(n, func(child pathNode) {
+			if s.filter.test(root, child.value) {
+				out = append(out, child)
+			}
+		})
+	}
+	return out
+}
+
+// bounds returns the bounds of a slice of an array of length n (RFC 9535,
+// section 2.3.4.2.2)
+func (s *selector) bounds(n int) (int, int) {
+	start, end := 0, n
+	if s.step < 0 {
+		start, end = n-1, -n-1
+	}
+	if s.hasStart {
+		start = s.index
+	}
+	if s.hasEnd {
+		end = s.end
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+
+	if s.step >= 0 {
+		return min(max(start, 0), n), min(max(end, 0), n)
+	}
+	return min(max(end, -1), n-1), min(max(start, -1), n-1)
+}
+
+// logicalExpr is a filter expression
+type logicalExpr interface {
+	test(root, current interface{}) bool
+}
+
+// valueExpr is an operand of a comparison. It reports false for Nothing,
+// the absence of a value.
+type valueExpr interface {
+	eval(root, current interface{}) (interface{}, bool)
+}
+
+type orExpr []logicalExpr
+
+func (e orExpr) test(root, current interface{}) bool {
+	for _, x := range e {
+		if x.test(root, current) {
+			return true
+		}
+	}
+	return false
+}
+
+type andExpr []logicalExpr
+
+func (e andExpr) test(root, current interface{}) bool {
+	for _, x := range e {
+		if !x.test(root, current) {
+			return false
+		}
+	}
+	return true
+}
+
+type notExpr struct {
+	expr logicalExpr
+}
+
+func (e notExpr) test(root, current interface{}) bool {
+	return !e.expr.test(root, current)
+}
+
+type compareExpr struct {
+	op          string
+	left, right valueExpr
+}
+
+func (e compareExpr) test(root, current interface{}) bool {
+	a, aok := e.left.eval(root, current)
+	b, bok := e.right.eval(root, current)
+	switch e.op {
+	case "==":
+		return equalValues(a, aok, b, bok)
+	case "!=":
+		return !equalValues(a, aok, b, bok)
+	case "<":
+		return aok && bok && lessValue(a, b)
+	case "<=":
+		return aok && bok && lessValue(a, b) || equalValues(a, aok, b, bok)
+	case ">":
+		return aok && bok && lessValue(b, a)
+	default:
+		return aok && bok && lessValue(b, a) || equalValues(a, aok, b, bok)
+	}
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l literal) eval(root, current interface{}) (interface{}, bool) {
+	return l.value, true
+}
+
+// pathNumber returns v as a number if it is one
+func pathNumber(v interface{}) (*big.Float, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, _, err := big.ParseFloat(string(v), 10, 256, big.ToNearestEven)
+		return f, err == nil
+	case float64:
+		if math.IsNaN(v) {
+			return nil, false
+		}
+		return big.NewFloat(v), true
+	case int:
+		return new(big.Float).SetInt64(int64(v)), true
+	}
+	return nil, false
+}
+
+func lessValue(a, b interface{}) bool {
+	if x, ok := pathNumber(a); ok {
+		y, ok := pathNumber(b)
+		return ok && x.Cmp(y) < 0
+	}
+	x, ok := a.(string)
+	y, ok2 := b.(string)
+	return ok && ok2 && x < y
+}
+
+func equalValues(a interface{}, aok bool, b interface{}, bok bool) bool {
+	if !aok || !bok {
+		return aok == bok
+	}
+	if x, ok := pathNumber(a); ok {
+		y, ok := pathNumber(b)
+		return ok && x.Cmp(y) == 0
+	}
+
+	switch x := a.(type) {
+	case nil, bool, string:
+		return a == b
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equalValues(x[i], true, y[i], true) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Objects are equal if they have the same members in any order
+	x, xn := objectMembers(a)
+	y, yn := objectMembers(b)
+	if xn < 0 || xn != yn {
+		return false
+	}
+	for key, xv := range x {
+		yv, ok := y[key]
+		if !ok || !equalValues(xv, true, yv, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// objectMembers returns the members of an object and their count, or -1
+// for other values
+func objectMembers(v interface{}) (map[string]interface{}, int) {
+	switch v := v.(type) {
+	case *OrderedMap:
+		m := make(map[string]interface{}, len(v.Keys))
+		for _, key := range v.Keys {
+			m[key] = v.Values[key].Value
+		}
+		return m, len(m)
+	case map[string]interface{}:
+		return v, len(v)
+	}
+	return nil, -1
+}
+
+// paramType is the type of a function parameter or result
+type paramType int
+
+const (
+	valueType paramType = iota
+	logicalType
+	nodesType
+)
+
+type pathFunc struct {
+	result paramType
+	params []paramType
+}
+
+var pathFuncs = map[string]pathFunc{
+	"length": {valueType, []paramType{valueType}},
+	"count":  {valueType, []paramType{nodesType}},
+	"match":  {logicalType, []paramType{valueType, valueType}},
+	"search": {logicalType, []paramType{valueType, valueType}},
+	"value":  {valueType, []paramType{nodesType}},
+}
+
+// funcExpr is a function call. Arguments are valueExpr or *filterQuery
+// depending on the parameter type.
+type funcExpr struct {
+	name string
+	args []interface{}
+	// re is the regular expression of match and search if it is a literal
+	re *regexp.Regexp
+}
+
+func (f *funcExpr) eval(root, current interface{}) (interface{}, bool) {
+	switch f.name {
+	case "length":
+		v, ok := f.args[0].(valueExpr).eval(root, current)
+		if !ok {
+			return nil, false
+		}
+		n := -1
+		switch v := v.(type) {
+		case string:
+			n = utf8.RuneCountInString(v)
+		case []interface{}:
+			n = len(v)
+		default:
+			_, n = objectMembers(v)
+		}
+		if n < 0 {
+			return nil, false
+		}
+		return json.Number(strconv.Itoa(n)), true
+	case "count":
+		n := len(f.args[0].(*filterQuery).nodes(root, current))
+		return json.Number(strconv.Itoa(n)), true
+	case "value":
+		return f.args[0].(*filterQuery).eval(root, current)
+	}
+	return nil, false
+}
+
+func (f *funcExpr) test(root, current interface{}) bool {
+	v, ok := f.args[0].(valueExpr).eval(root, current)
+	s, isString := v.(string)
+	if !ok || !isString {
+		return false
+	}
+	re := f.re
+	if re == nil {
+		pattern, ok := f.args[1].(valueExpr).eval(root, current)
+		p, isString := pattern.(string)
+		if !ok || !isString {
+			return false
+		}
+		if re = compileIRegexp(p, f.name == "match"); re == nil {
+			return false
+		}
+	}
+	return re.MatchString(s)
+}
+
+// compileIRegexp compiles an I-Regexp (RFC 9485). Its dot does not match
+// line breaks. It returns nil for invalid expressions.
+func compileIRegexp(pattern string, full bool) *regexp.Regexp {
+	var b strings.Builder
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			b.WriteByte(c)
+			i++
+			c = pattern[i]
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '.' && !inClass:
+			b.WriteString(`[^\n\r]`)
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	expr := b.String()
+	if full {
+		expr = `^(?:` + expr + `)$`
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// pathParser parses JSONPath queries
+type pathParser struct {
+	expr string
+	pos  int
+}
+
+func (p *pathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("jsonedit: invalid JSONPath %q: "+format+" at offset %d",
+		append(append([]interface{}{p.expr}, args...), p.pos)...)
+}
+
+func (p *pathParser) peek() byte {
+	if p.pos < len(p.expr) {
+		return p.expr[p.pos]
+	}
+	return 0
+}
+
+func (p *pathParser) blank() {
+	for p.pos < len(p.expr) {
+		switch p.expr[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// query parses a query starting with $ or @
+func (p *pathParser) query() (*filterQuery, error) {
+	q := &filterQuery{relative: p.peek() == '@'}
+	p.pos++
+	for {
+		start := p.pos
+		p.blank()
+
+		var seg segment
+		var err error
+		switch p.peek() {
+		case '[':
+			seg.selectors, err = p.bracketed()
+		case '.':
+			seg, err = p.dotted()
+		default:
+			p.pos = start
+			return q, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		q.segments = append(q.segments, seg)
+	}
+}
+
+// dotted parses the segments .name, .*, ..name, ..* and ..[selectors]
+func (p *pathParser) dotted() (segment, error) {
+	var seg segment
+	p.pos++
+	if p.peek() == '.' {
+		p.pos++
+		seg.descendant = true
+		if p.peek() == '[' {
+			var err error
+			seg.selectors, err = p.bracketed()
+			return seg, err
+		}
+	}
+
+	if p.peek() == '*' {
+		p.pos++
+		seg.selectors = []selector{{kind: selectWildcard}}
+		return seg, nil
+	}
+	start := p.pos
+	for p.pos < len(p.expr) {
+		r, size := utf8.DecodeRuneInString(p.expr[p.pos:])
+		if !isNameChar(r) || p.pos == start && '0' <= r && r <= '9' {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		return seg, p.errorf("expected member name")
+	}
+	seg.selectors = []selector{{kind: selectName, name: p.expr[start:p.pos]}}
+	return seg, nil
+}
+
+// isNameChar reports whether r may appear in a member name shorthand
+func isNameChar(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' ||
+		r == '_' || r >= 0x80 && r != utf8.RuneError
+}
+
+// bracketed parses [selector, ...]
+func (p *pathParser) bracketed() ([]selector, error) {
+	p.pos++
+	var selectors []selector
+	for {
+		p.blank()
+		s, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, s)
+
+		p.blank()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return selectors, nil
+		default:
+			return nil, p.errorf("expected , or ]")
+		}
+	}
+}
+
+func (p *pathParser) selector() (selector, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		name, err := p.string()
+		return selector{kind: selectName, name: name}, err
+	case c == '*':
+		p.pos++
+		return selector{kind: selectWildcard}, nil
+	case c == '?':
+		p.pos++
+		p.blank()
+		filter, err := p.logicalOr()
+		return selector{kind: selectFilter, filter: filter}, err
+	}
+
+	s := selector{kind: selectIndex}
+	var err error
+	if c := p.peek(); c == '-' || isDigit(c) {
+		if s.index, err = p.int(); err != nil {
+			return s, err
+		}
+		s.hasStart = true
+	}
+	afterStart := p.pos
+	p.blank()
+	if p.peek() != ':' {
+		if !s.hasStart {
+			return s, p.errorf("expected selector")
+		}
+		p.pos = afterStart
+		return s, nil
+	}
+
+	// Slice start:end:step
+	s.kind, s.step = selectSlice, 1
+	p.pos++
+	p.blank()
+	if c := p.peek(); c == '-' || isDigit(c) {
+		if s.end, err = p.int(); err != nil {
+			return s, err
+		}
+		s.hasEnd = true
+		p.blank()
+	}
+	if p.peek() == ':' {
+		p.pos++
+		p.blank()
+		if c := p.peek(); c == '-' || isDigit(c) {
+			if s.step, err = p.int(); err != nil {
+				return s, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// maxPathInt is the largest integer allowed in queries, 2^53-1
+const maxPathInt = 1<<53 - 1
+
+// int parses an integer without leading zeros
+func (p *pathParser) int() (int, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	digits := p.pos
+	for isDigit(p.peek()) {
+		p.pos++
+	}
+	lit := p.expr[start:p.pos]
+	switch {
+	case p.pos == digits:
+		return 0, p.errorf("expected digit")
+	case p.expr[digits] == '0' && (p.pos-digits > 1 || digits > start):
+		return 0, p.errorf("invalid integer %s", lit)
+	}
+	n, err := strconv.Atoi(lit)
+	if err != nil || n > maxPathInt || n < -maxPathInt {
+		return 0, p.errorf("integer %s out of range", lit)
+	}
+	return n, nil
+}
+
+// string parses a string literal in single or double quotes
+func (p *pathParser) string() (string, error) {
+	quote := p.expr[p.pos]
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.expr) {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.expr[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), nil
+		case c < 0x20:
+			return "", p.errorf("control character in string")
+		case c != '\\':
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+
+		p.pos++
+		switch e := p.peek(); e {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '/', '\\', quote:
+			b.WriteByte(e)
+		case 'u':
+			r, err := p.unicodeEscape()
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(r)
+			continue
+		default:
+			return "", p.errorf("invalid escape")
+		}
+		p.pos++
+	}
+}
+
+// unicodeEscape parses uXXXX after a backslash, combining surrogate pairs
+func (p *pathParser) unicodeEscape() (rune, error) {
+	hex := func() (rune, bool) {
+		if p.pos+5 > len(p.expr) || !allHex([]byte(p.expr[p.pos+1:p.pos+5])) {
+			return 0, false
+		}
+		r := hexRune(p.expr[p.pos+1 : p.pos+5])
+		p.pos += 5
+		return r, true
+	}
+
+	r, ok := hex()
+	if !ok {
+		return 0, p.errorf("invalid \\u escape")
+	}
+	switch {
+	case utf16.IsSurrogate(r) && r < 0xDC00:
+		if !strings.HasPrefix(p.expr[p.pos:], `\u`) {
+			return 0, p.errorf("unpaired surrogate")
+		}
+		p.pos++
+		low, ok := hex()
+		if !ok || low < 0xDC00 || low > 0xDFFF {
+			return 0, p.errorf("unpaired surrogate")
+		}
+		return utf16.DecodeRune(r, low), nil
+	case utf16.IsSurrogate(r):
+		return 0, p.errorf("unpaired surrogate")
+	}
+	return r, nil
+}
+
+func (p *pathParser) logicalOr() (logicalExpr, error) {
+	return p.logicalList("||", p.logicalAnd, func(list []logicalExpr) logicalExpr { return orExpr(list) })
+}
+
+func (p *pathParser) logicalAnd() (logicalExpr, error) {
+	return p.logicalList("&&", p.basic, func(list []logicalExpr) logicalExpr { return andExpr(list) })
+}
+
+// logicalList parses operands separated by op
+func (p *pathParser) logicalList(op string, operand func() (logicalExpr, error), join func([]logicalExpr) logicalExpr) (logicalExpr, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	list := []logicalExpr{first}
+	for {
+		start := p.pos
+		p.blank()
+		if !strings.HasPrefix(p.expr[p.pos:], op) {
+			p.pos = start
+			break
+		}
+		p.pos += len(op)
+		p.blank()
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, next)
+	}
+	if len(list) == 1 {
+		return first, nil
+	}
+	return join(list), nil
+}
+
+// basic parses a parenthesized, negated, comparison or test expression
+func (p *pathParser) basic() (logicalExpr, error) {
+	switch p.peek() {
+	case '!':
+		p.pos++
+		p.blank()
+		var e logicalExpr
+		var err error
+		if p.peek() == '(' {
+			e, err = p.paren()
+		} else {
+			e, err = p.testExpr()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	case '(':
+		return p.paren()
+	}
+
+	start := p.pos
+	if e, err := p.testExpr(); err == nil {
+		// A query or function followed by a comparison operator is the left
+		// operand of a comparison
+		end := p.pos
+		p.blank()
+		if p.comparisonOp() == "" {
+			p.pos = end
+			return e, nil
+		}
+	}
+	p.pos = start
+
+	left, err := p.comparable()
+	if err != nil {
+		return nil, err
+	}
+	p.blank()
+	op := p.comparisonOp()
+	if op == "" {
+		return nil, p.errorf("expected comparison operator")
+	}
+	p.pos += len(op)
+	p.blank()
+	right, err := p.comparable()
+	if err != nil {
+		return nil, err
+	}
+	return compareExpr{op: op, left: left, right: right}, nil
+}
+
+func (p *pathParser) paren() (logicalExpr, error) {
+	p.pos++
+	p.blank()
+	e, err := p.logicalOr()
+	if err != nil {
+		return nil, err
+	}
+	p.blank()
+	if p.peek() != ')' {
+		return nil, p.errorf("expected )")
+	}
+	p.pos++
+	return e, nil
+}
+
+func (p *pathParser) comparisonOp() string {
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(p.expr[p.pos:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// testExpr parses a query tested for existence or a function returning a
+// logical value
+func (p *pathParser) testExpr() (logicalExpr, error) {
+	if c := p.peek(); c == '@' || c == '$' {
+		return p.query()
+	}
+	f, err := p.function()
+	if err != nil {
+		return nil, err
+	}
+	if pathFuncs[f.name].result != logicalType {
+		return nil, p.errorf("result of %s() cannot be tested", f.name)
+	}
+	return f, nil
+}
+
+// comparable parses a literal, singular query or function returning a value
+func (p *pathParser) comparable() (valueExpr, error) {
+	c := p.peek()
+	switch {
+	case c == '@' || c == '$':
+		q, err := p.query()
+		if err != nil {
+			return nil, err
+		}
+		if !q.singular() {
+			return nil, p.errorf("query does not select a single value")
+		}
+		return q, nil
+	case c == '\'' || c == '"':
+		s, err := p.string()
+		return literal{s}, err
+	case c == '-' || isDigit(c):
+		return p.number()
+	}
+
+	for _, lit := range []struct {
+		word  string
+		value interface{}
+	}{{"true", true}, {"false", false}, {"null", nil}} {
+		if strings.HasPrefix(p.expr[p.pos:], lit.word) && !isNameChar(rune(p.peekAt(len(lit.word)))) {
+			p.pos += len(lit.word)
+			return literal{lit.value}, nil
+		}
+	}
+
+	f, err := p.function()
+	if err != nil {
+		return nil, err
+	}
+	if pathFuncs[f.name].result != valueType {
+		return nil, p.errorf("result of %s() cannot be compared", f.name)
+	}
+	return f, nil
+}
+
+// peekAt returns the byte i bytes ahead or 0
+func (p *pathParser) peekAt(i int) byte {
+	if p.pos+i < len(p.expr) {
+		return p.expr[p.pos+i]
+	}
+	return 0
+}
+
+func (p *pathParser) number() (valueExpr, error) {
+	start := p.pos
+	for p.pos < len(p.expr) && strings.IndexByte("+-.0123456789eE", p.expr[p.pos]) >= 0 {
+		p.pos++
+	}
+	lit := p.expr[start:p.pos]
+	if !json.Valid([]byte(lit)) {
+		p.pos = start
+		return nil, p.errorf("invalid number %s", lit)
+	}
+	return literal{json.Number(lit)}, nil
+}
+
+// function parses a function call and checks its arguments
+func (p *pathParser) function() (*funcExpr, error) {
+	start := p.pos
+	if c := p.peek(); c < 'a' || c > 'z' {
+		return nil, p.errorf("expected query, literal or function")
+	}
+	for c := p.peek(); 'a' <= c && c <= 'z' || isDigit(c) || c == '_'; c = p.peek() {
+		p.pos++
+	}
+	f := &funcExpr{name: p.expr[start:p.pos]}
+	def, ok := pathFuncs[f.name]
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown function %s", f.name)
+	}
+	if p.peek() != '(' {
+		return nil, p.errorf("expected (")
+	}
+	p.pos++
+
+	for i, param := range def.params {
+		p.blank()
+		if i > 0 {
+			if p.peek() != ',' {
+				return nil, p.errorf("%s() takes %d arguments", f.name, len(def.params))
+			}
+			p.pos++
+			p.blank()
+		}
+		switch param {
+		case valueType:
+			arg, err := p.comparable()
+			if err != nil {
+				return nil, err
+			}
+			f.args = append(f.args, arg)
+		case nodesType:
+			if c := p.peek(); c != '@' && c != '$' {
+				return nil, p.errorf("argument of %s() must be a query", f.name)
+			}
+			q, err := p.query()
+			if err != nil {
+				return nil, err
+			}
+			f.args = append(f.args, q)
+		}
+	}
+	p.blank()
+	if p.peek() != ')' {
+		return nil, p.errorf("%s() takes %d arguments", f.name, len(def.params))
+	}
+	p.pos++
+
+	// Regular expressions given as literals are compiled once
+	if lit, ok := f.args[len(f.args)-1].(literal); ok && def.result == logicalType {
+		pattern, isString := lit.value.(string)
+		if f.re = compileIRegexp(pattern, f.name == "match"); f.re == nil || !isString {
+			// Never matches
+			f.re = regexp.MustCompile(`[^\x00-\x{10FFFF}]`)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,165 @@
+package jsonedit
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+var (
+	marshalerType     = reflect.TypeFor[json.Marshaler]()
+	unmarshalerType   = reflect.TypeFor[json.Unmarshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// marshalKind tells how values of a type encode themselves
+type marshalKind uint8
+
+const (
+	marshalNone marshalKind = iota
+	marshalJSON
+	marshalJSONPointer
+	marshalText
+	marshalTextPointer
+)
+
+var marshalCache sync.Map // map[reflect.Type]marshalKind
+
+// cachedMarshalKind returns the marshalKind of t, resolving every type once
+func cachedMarshalKind(t reflect.Type) marshalKind {
+	if k, ok := marshalCache.Load(t); ok {
+		return k.(marshalKind)
+	}
+
+	k := marshalNone
+	switch {
+	case t.Implements(marshalerType):
+		k = marshalJSON
+	case t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(marshalerType):
+		k = marshalJSONPointer
+	case t.Implements(textMarshalerType):
+		k = marshalText
+	case t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(textMarshalerType):
+		k = marshalTextPointer
+	}
+	marshalCache.Store(t, k)
+	return k
+}
+
+// marshalerOf returns v as json.Marshaler or encoding.TextMarshaler if it
+// implements one of them, json.Marshaler taking precedence. Values whose
+// pointer implements the interface are copied to call the pointer method, as
+// encoding/json does for addressable values.
+func marshalerOf(v reflect.Value) (interface{}, bool) {
+	if !v.IsValid() {
+		return nil, false
+	}
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return nil, false
+	}
+
+	switch cachedMarshalKind(v.Type()) {
+	case marshalJSON, marshalText:
+		return v.Interface(), true
+	case marshalJSONPointer, marshalTextPointer:
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface(), true
+	}
+	return nil, false
+}
+
+// isUnmarshaler reports whether t decodes itself from JSON
+func isUnmarshaler(t reflect.Type) bool {
+	return t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType)
+}
+
+// encodeMarshaler writes the output of a json.Marshaler or
+// encoding.TextMarshaler. Values that decode from the original unchanged are
+// written from the source. Otherwise the output is parsed and written like any
+// other value, so it follows Format and unchanged parts keep their text.
+func (ce *customEncoder) encodeMarshaler(v reflect.Value, m interface{}, n *node, indent string) error {
+	if n != nil {
+		data := n.appendCanonical(nil)
+		orig := reflect.New(v.Type())
+		if json.Unmarshal(data, orig.Interface()) == nil && reflect.DeepEqual(orig.Elem().Interface(), v.Interface()) {
+			_, value, err := parseTree(data, options{})
+			if err != nil {
+				return err
+			}
+			return ce.encode(value, n, indent)
+		}
+	}
+
+	switch m := m.(type) {
+	case json.Marshaler:
+		data, err := m.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("jsonedit: error calling MarshalJSON for type %T: %w", m, err)
+		}
+		_, value, err := parseTree(data, options{})
+		if err != nil {
+			return fmt.Errorf("jsonedit: invalid output of MarshalJSON for type %T: %w", m, err)
+		}
+		return ce.encode(value, n, indent)
+	case encoding.TextMarshaler:
+		text, err := m.MarshalText()
+		if err != nil {
+			return fmt.Errorf("jsonedit: error calling MarshalText for type %T: %w", m, err)
+		}
+		return ce.encode(string(text), n, indent)
+	}
+	return fmt.Errorf("jsonedit: unsupported marshaler %T", m)
+}
+
+// isMapKey reports whether maps with key type t are encoded as objects
+func isMapKey(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return t.Implements(textMarshalerType)
+}
+
+// mapKeys returns the keys of the map v by their JSON object key. Like
+// encoding/json string kinds are used as is, then encoding.TextMarshaler is
+// used and integers are formatted in decimal.
+func mapKeys(v reflect.Value) (map[string]reflect.Value, error) {
+	keys := make(map[string]reflect.Value, v.Len())
+	for _, k := range v.MapKeys() {
+		name, err := mapKey(k)
+		if err != nil {
+			return nil, err
+		}
+		keys[name] = k
+	}
+	return keys, nil
+}
+
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		text, err := tm.MarshalText()
+		if err != nil {
+			return "", fmt.Errorf("jsonedit: error calling MarshalText for map key of type %s: %w", k.Type(), err)
+		}
+		return string(text), nil
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("jsonedit: unsupported map key type %s", k.Type())
+}
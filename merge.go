@@ -0,0 +1,483 @@
+package jsonedit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// PlacementMode selects where keys added to a typed map are inserted
+type PlacementMode int
+
+const (
+	// AppendSorted appends new keys in sorted order
+	AppendSorted PlacementMode = iota
+	// InsertSorted inserts new keys alphabetically if the existing keys are
+	// sorted, as npm does for dependencies, and appends them otherwise
+	InsertSorted
+	// InsertAfter inserts new keys in sorted order after Placement.Anchor,
+	// or appends them if the map has no such key
+	InsertAfter
+)
+
+// Placement decides where keys added to a typed map are inserted
+type Placement struct {
+	Mode   PlacementMode
+	Anchor string
+}
+
+// place returns the final key order given the existing and new keys. New
+// keys must be sorted.
+func (p Placement) place(existing, added []string) []string {
+	keys := make([]string, 0, len(existing)+len(added))
+
+	switch p.Mode {
+	case InsertSorted:
+		if !sort.StringsAreSorted(existing) {
+			break
+		}
+		i, j := 0, 0
+		for i < len(existing) && j < len(added) {
+			if added[j] < existing[i] {
+				keys = append(keys, added[j])
+				j++
+			} else {
+				keys = append(keys, existing[i])
+				i++
+			}
+		}
+		keys = append(keys, existing[i:]...)
+		return append(keys, added[j:]...)
+	case InsertAfter:
+		for i, key := range existing {
+			if key == p.Anchor {
+				keys = append(keys, existing[:i+1]...)
+				keys = append(keys, added...)
+				return append(keys, existing[i+1:]...)
+			}
+		}
+	}
+
+	keys = append(keys, existing...)
+	return append(keys, added...)
+}
+
+// merger combines typed data with the ordered tree it was decoded from
+type merger struct {
+	placement   Placement
+	identityKey string
+}
+
+// mergedArray is a typed slice merged with the array it was decoded from.
+// source holds the index of the original element each value was matched
+// with, or -1 for new elements.
+type mergedArray struct {
+	values []interface{}
+	source []int
+}
+
+// mergeObject merges the fields of the struct v into the object orig it was
+// decoded from. Keys without a field are taken from rest, typed keys keep
+// their original position and typed keys missing in the original are
+// inserted following the struct field order. Nested structs are merged
+// recursively, so unknown keys survive at every depth.
+func (mg *merger) mergeObject(v reflect.Value, orig *OrderedMap, rest *OrderedMap) *OrderedMap {
+	result := NewOrderedMap()
+
+	// Get typed fields mapping and order. Omitted fields are still looked up
+	// so that they are not taken from rest.
+	var fields structFields
+	typedFields := make(map[string]interface{})
+	typedOrder := []string{}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		fields = cachedTypeFields(v.Type())
+		for i := range fields.list {
+			f := &fields.list[i]
+			fieldValue, ok := f.value(v)
+			if !ok || f.omit(fieldValue) {
+				continue
+			}
+
+			typedFields[f.name] = f.encoded(fieldValue)
+			typedOrder = append(typedOrder, f.name)
+		}
+	}
+
+	// Keys match fields case-insensitively and the last key matching a field
+	// is the one it was decoded from
+	source := make(map[string]string)
+	for _, key := range orig.Keys {
+		if f := fields.lookup(key); f != nil {
+			source[f.name] = key
+		}
+	}
+
+	typedPos := make(map[string]int, len(typedOrder))
+	for i, name := range typedOrder {
+		typedPos[name] = i
+	}
+
+	// Iterate through original order
+	typedIndex := 0
+	for _, key := range orig.Keys {
+		f := fields.lookup(key)
+		if f == nil {
+			if val, ok := rest.Get(key); ok {
+				// Use rest value
+				result.Set(key, val, len(result.Keys))
+			}
+			continue
+		}
+
+		origVal, _ := orig.Get(key)
+		if source[f.name] != key {
+			// Overwritten by a later key while decoding, keep it as it was
+			result.Set(key, origVal, len(result.Keys))
+			continue
+		}
+
+		// Ensure any preceding typed keys that were missing in the original
+		// document are emitted before the current typed key.
+		if pos, isTypedKey := typedPos[f.name]; isTypedKey && pos >= typedIndex {
+			for ; typedIndex < pos; typedIndex++ {
+				missingKey := typedOrder[typedIndex]
+				if _, inOriginal := source[missingKey]; !inOriginal {
+					result.Set(missingKey, typedFields[missingKey], len(result.Keys))
+				}
+			}
+			typedIndex = pos + 1
+		}
+
+		// Omitted typed fields are dropped
+		if typedVal, ok := typedFields[f.name]; ok {
+			result.Set(key, mg.mergeValue(typedVal, origVal), len(result.Keys))
+		}
+	}
+
+	// Append any remaining typed keys that were not present in the original
+	// document.
+	for ; typedIndex < len(typedOrder); typedIndex++ {
+		key := typedOrder[typedIndex]
+		if _, inOriginal := source[key]; !inOriginal {
+			result.Set(key, typedFields[key], len(result.Keys))
+		}
+	}
+
+	// Keys added to rest come last
+	for _, key := range rest.Keys {
+		if _, inOriginal := orig.Values[key]; !inOriginal && fields.lookup(key) == nil {
+			result.Set(key, rest.Values[key].Value, len(result.Keys))
+		}
+	}
+
+	return result
+}
+
+// mergeValue merges a typed value into the original value it was decoded from
+func (mg *merger) mergeValue(typedVal interface{}, origVal interface{}) interface{} {
+	// Types encoding themselves are written as they are
+	if _, ok := marshalerOf(reflect.ValueOf(typedVal)); ok {
+		return typedVal
+	}
+
+	// Only nested objects and arrays need merging
+	if origArr, ok := origVal.([]interface{}); ok {
+		return mg.mergeArray(typedVal, origArr)
+	}
+	origMap, isOrderedMap := origVal.(*OrderedMap)
+	if !isOrderedMap {
+		return typedVal
+	}
+
+	v := reflect.ValueOf(typedVal)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Struct:
+		// Unknown keys of nested objects are kept from the original
+		return mg.mergeObject(v, origMap, origMap)
+	case v.Kind() == reflect.Map && isMapKey(v.Type().Key()) && !v.IsNil():
+		return mg.mergeMap(v, origMap)
+	}
+
+	return typedVal
+}
+
+// mergeMap merges a typed map into the object it was decoded from. Existing
+// keys keep their original order, deleted keys are dropped and new keys are
+// placed according to the placement policy. Values are merged recursively.
+// Maps whose keys cannot be encoded are returned as they are, so that the
+// encoder reports the error.
+func (mg *merger) mergeMap(v reflect.Value, orig *OrderedMap) interface{} {
+	byKey, err := mapKeys(v)
+	if err != nil {
+		return v.Interface()
+	}
+	mergedMap := NewOrderedMap()
+
+	// First add existing keys that are still in typed map (preserving order)
+	for _, origKey := range orig.Keys {
+		if k, ok := byKey[origKey]; ok {
+			origVal, _ := orig.Get(origKey)
+			mergedMap.Set(origKey, mg.mergeValue(v.MapIndex(k).Interface(), origVal), len(mergedMap.Keys))
+		}
+		// Don't add keys that were deleted from typed map
+	}
+
+	// Then add any new keys from typed map
+	var newKeys []string
+	for key := range byKey {
+		if _, exists := mergedMap.Values[key]; !exists {
+			newKeys = append(newKeys, key)
+		}
+	}
+	sort.Strings(newKeys)
+
+	keys := mg.placement.place(mergedMap.Keys, newKeys)
+	result := NewOrderedMap()
+	for _, key := range keys {
+		if ov, exists := mergedMap.Values[key]; exists {
+			result.Set(key, ov.Value, len(result.Keys))
+		} else {
+			result.Set(key, v.MapIndex(byKey[key]).Interface(), len(result.Keys))
+		}
+	}
+
+	return result
+}
+
+// mergeArray merges a typed slice into the array it was decoded from. Elements
+// are matched with the original ones by their identity key if one is set.
+// Otherwise unchanged elements are matched with an original one they decode
+// from, preferring the same index, and edited ones with the original they
+// share most fields with. Unknown keys and formatting follow the element
+// they belong to.
+func (mg *merger) mergeArray(typedVal interface{}, orig []interface{}) interface{} {
+	v := reflect.ValueOf(typedVal)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array || v.Kind() == reflect.Slice && v.IsNil() {
+		return typedVal
+	}
+
+	merged := &mergedArray{
+		values: make([]interface{}, v.Len()),
+		source: make([]int, v.Len()),
+	}
+	em := &elementMatcher{
+		orig:    orig,
+		used:    make([]bool, len(orig)),
+		decoded: make([]reflect.Value, len(orig)),
+	}
+	// Edited elements are matched once all unchanged ones have their place
+	var edited []int
+	for i := range merged.values {
+		j, ok := mg.matchElement(v.Index(i), i, em)
+		if !ok {
+			edited = append(edited, i)
+		}
+		merged.source[i] = j
+	}
+	for _, i := range edited {
+		merged.source[i] = em.closest(v.Index(i), i)
+	}
+
+	for i := range merged.values {
+		elem := v.Index(i).Interface()
+		j := merged.source[i]
+		if j < 0 {
+			merged.values[i] = elem
+			continue
+		}
+		merged.values[i] = mg.mergeValue(elem, orig[j])
+	}
+	return merged
+}
+
+// matchElement returns the index of the original element the i-th typed
+// element was decoded from or -1 if there is none. It reports false if the
+// element was edited and should be matched by em.closest.
+func (mg *merger) matchElement(elem reflect.Value, i int, em *elementMatcher) (int, bool) {
+	if mg.identityKey != "" {
+		if id, ok := identity(elem, mg.identityKey); ok {
+			for j, o := range em.orig {
+				om, isMap := o.(*OrderedMap)
+				if !isMap || em.used[j] {
+					continue
+				}
+				if origID, ok := om.Get(mg.identityKey); ok && sameIdentity(origID, id) {
+					em.used[j] = true
+					return j, true
+				}
+			}
+			return -1, true
+		}
+	}
+
+	if i < len(em.orig) && !em.used[i] && em.decodesTo(i, elem) {
+		em.used[i] = true
+		return i, true
+	}
+	for j := range em.orig {
+		if !em.used[j] && em.decodesTo(j, elem) {
+			em.used[j] = true
+			return j, true
+		}
+	}
+	return -1, false
+}
+
+// elementMatcher pairs typed array elements with the original elements
+type elementMatcher struct {
+	orig []interface{}
+	used []bool
+	// decoded holds the original elements decoded into the type of the
+	// typed elements
+	decoded []reflect.Value
+}
+
+// decode returns the j-th original element decoded into type t
+func (em *elementMatcher) decode(j int, t reflect.Type) (reflect.Value, bool) {
+	if d := em.decoded[j]; d.IsValid() && d.Type() == t {
+		return d, true
+	}
+	tree, _, err := toGeneric(em.orig[j])
+	if err != nil {
+		return reflect.Value{}, false
+	}
+	target := reflect.New(t)
+	if err := decodeTree(tree, target.Interface()); err != nil {
+		return reflect.Value{}, false
+	}
+	em.decoded[j] = target.Elem()
+	return em.decoded[j], true
+}
+
+// decodesTo reports whether the j-th original element decodes to elem
+func (em *elementMatcher) decodesTo(j int, elem reflect.Value) bool {
+	elem = concrete(elem)
+	if !elem.IsValid() {
+		return em.orig[j] == nil
+	}
+	d, ok := em.decode(j, elem.Type())
+	return ok && reflect.DeepEqual(d.Interface(), elem.Interface())
+}
+
+// closest returns the unused original element the edited i-th element shares
+// the most non-zero field values with, the nearest one if several do. Elements
+// sharing nothing are new. Elements without fields keep their index.
+func (em *elementMatcher) closest(elem reflect.Value, i int) int {
+	elem = concrete(elem)
+	if !elem.IsValid() || elem.Kind() != reflect.Struct && elem.Kind() != reflect.Map {
+		if i < len(em.orig) && !em.used[i] {
+			em.used[i] = true
+			return i
+		}
+		return -1
+	}
+
+	best, bestScore := -1, 0
+	for j := range em.orig {
+		if em.used[j] {
+			continue
+		}
+		d, ok := em.decode(j, elem.Type())
+		if !ok {
+			continue
+		}
+		score := sharedFields(elem, d)
+		if score > bestScore || score == bestScore && best >= 0 && abs(j-i) < abs(best-i) {
+			best, bestScore = j, score
+		}
+	}
+	if best >= 0 {
+		em.used[best] = true
+	}
+	return best
+}
+
+// concrete returns the value behind pointers and interfaces, or the zero
+// Value for nil
+func concrete(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// sharedFields counts the non-zero fields or map entries a and b have in
+// common
+func sharedFields(a, b reflect.Value) int {
+	n := 0
+	if a.Kind() == reflect.Map {
+		for _, k := range a.MapKeys() {
+			av, bv := a.MapIndex(k), b.MapIndex(k)
+			if bv.IsValid() && !av.IsZero() && reflect.DeepEqual(av.Interface(), bv.Interface()) {
+				n++
+			}
+		}
+		return n
+	}
+	fields := cachedTypeFields(a.Type())
+	for i := range fields.list {
+		f := &fields.list[i]
+		av, aok := f.value(a)
+		bv, bok := f.value(b)
+		if aok && bok && !av.IsZero() && reflect.DeepEqual(av.Interface(), bv.Interface()) {
+			n++
+		}
+	}
+	return n
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// identity returns the value of key in a struct or map element
+func identity(v reflect.Value, key string) (interface{}, bool) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := cachedTypeFields(v.Type())
+		if f := fields.lookup(key); f != nil {
+			if fv, ok := f.value(v); ok {
+				return fv.Interface(), true
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if val := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())); val.IsValid() {
+			return val.Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// sameIdentity reports whether the original value orig and the typed value v
+// identify the same element
+func sameIdentity(orig, v interface{}) bool {
+	if lit, ok := orig.(json.Number); ok {
+		return numberMatches(string(lit), v)
+	}
+	return reflect.DeepEqual(orig, v)
+}
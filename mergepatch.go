@@ -0,0 +1,72 @@
+package jsonedit
+
+import "io"
+
+// MergePatch applies a JSON Merge Patch (RFC 7386) to the document. Members
+// set to null are removed, objects are merged recursively and other values
+// replace the ones in the document. Existing keys keep their place, new keys
+// are added in the order of the patch. Nulls remove struct fields like Delete
+// does. If the patch cannot be applied the document is left unchanged.
+func (d *Document[T]) MergePatch(patch io.Reader) error {
+	data, err := io.ReadAll(patch)
+	if err != nil {
+		return err
+	}
+	_, value, err := parseTree(data, options{})
+	if err != nil {
+		return err
+	}
+
+	var undo []func()
+	if err := d.mergePatch(nil, value, &undo); err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		return err
+	}
+	return nil
+}
+
+// mergePatch merges patch into the value at tokens
+func (d *Document[T]) mergePatch(tokens []string, patch interface{}, undo *[]func()) error {
+	pointer := formatPointer(tokens)
+	om, ok := patch.(*OrderedMap)
+	if !ok {
+		return d.access(&access{op: pointerSet, value: patch, undo: undo}, pointer)
+	}
+	target, err := d.genericAt(pointer)
+	if _, isObject := target.(*OrderedMap); err != nil || !isObject {
+		return d.access(&access{op: pointerSet, value: withoutNulls(om), undo: undo}, pointer)
+	}
+
+	for _, key := range om.Keys {
+		path := append(tokens[:len(tokens):len(tokens)], key)
+		value := om.Values[key].Value
+		if value != nil {
+			err = d.mergePatch(path, value, undo)
+		} else if p := formatPointer(path); d.Has(p) {
+			err = d.access(&access{op: pointerDelete, undo: undo}, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutNulls returns a copy of om without null members, which is what
+// merging it into an empty object gives
+func withoutNulls(om *OrderedMap) *OrderedMap {
+	out := NewOrderedMap()
+	for _, key := range om.Keys {
+		value := om.Values[key].Value
+		switch v := value.(type) {
+		case nil:
+			continue
+		case *OrderedMap:
+			value = withoutNulls(v)
+		}
+		out.Set(key, value, len(out.Keys))
+	}
+	return out
+}
@@ -0,0 +1,189 @@
+package jsonedit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PatchError describes the operation of a JSON Patch that could not be
+// applied. Index is the position of the operation in the patch.
+type PatchError struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("jsonedit: patch operation %d (%s %q): %s",
+		e.Index, e.Op, e.Path, strings.TrimPrefix(e.Err.Error(), "jsonedit: "))
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// PatchOperation is one operation of a JSON Patch. From is used by move and
+// copy, Value by add, replace and test.
+type PatchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// MarshalJSON encodes the operation with the members its kind uses
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	om := NewOrderedMap()
+	om.Set("op", op.Op, 0)
+	om.Set("path", op.Path, 1)
+	switch op.Op {
+	case "move", "copy":
+		om.Set("from", op.From, 2)
+	case "add", "replace", "test":
+		om.Set("value", op.Value, 2)
+	}
+	var buf bytes.Buffer
+	if err := encodeCompact(&buf, om); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parsePatch reads a JSON Patch document
+func parsePatch(r io.Reader) ([]PatchOperation, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	_, value, err := parseTree(data, options{})
+	if err != nil {
+		return nil, err
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("jsonedit: JSON Patch must be an array of operations")
+	}
+
+	ops := make([]PatchOperation, len(list))
+	for i, item := range list {
+		om, ok := item.(*OrderedMap)
+		if !ok {
+			return nil, &PatchError{Index: i, Err: errors.New("operation must be an object")}
+		}
+		op := &ops[i]
+		if err := patchMember(om, "op", &op.Op); err != nil {
+			return nil, &PatchError{Index: i, Err: err}
+		}
+		if err := patchMember(om, "path", &op.Path); err != nil {
+			return nil, &PatchError{Index: i, Op: op.Op, Err: err}
+		}
+
+		var hasValue bool
+		op.Value, hasValue = om.Get("value")
+		switch op.Op {
+		case "add", "replace", "test":
+			if !hasValue {
+				err = errors.New(`missing member "value"`)
+			}
+		case "move", "copy":
+			err = patchMember(om, "from", &op.From)
+		case "remove":
+		default:
+			err = fmt.Errorf("unknown operation %q", op.Op)
+		}
+		if err != nil {
+			return nil, &PatchError{Index: i, Op: op.Op, Path: op.Path, Err: err}
+		}
+	}
+	return ops, nil
+}
+
+// patchMember reads the string member key of an operation into s
+func patchMember(om *OrderedMap, key string, s *string) error {
+	v, ok := om.Get(key)
+	if !ok {
+		return fmt.Errorf("missing member %q", key)
+	}
+	if *s, ok = v.(string); !ok {
+		return fmt.Errorf("member %q must be a string", key)
+	}
+	return nil
+}
+
+// ApplyPatch applies a JSON Patch (RFC 6902) to the document. Either all
+// operations are applied or, if one fails, none. Struct fields can only be
+// removed if Delete can remove them.
+func (d *Document[T]) ApplyPatch(patch io.Reader) error {
+	ops, err := parsePatch(patch)
+	if err != nil {
+		return err
+	}
+
+	var undo []func()
+	for i, op := range ops {
+		if err := d.applyOp(op, &undo); err != nil {
+			for j := len(undo) - 1; j >= 0; j-- {
+				undo[j]()
+			}
+			return &PatchError{Index: i, Op: op.Op, Path: op.Path, Err: err}
+		}
+	}
+	return nil
+}
+
+// applyOp applies a single patch operation, collecting how to undo it
+func (d *Document[T]) applyOp(op PatchOperation, undo *[]func()) error {
+	switch op.Op {
+	case "add":
+		return d.access(&access{op: pointerAdd, value: op.Value, undo: undo}, op.Path)
+	case "remove":
+		if op.Path == "" {
+			return errors.New("cannot remove the whole document")
+		}
+		return d.access(&access{op: pointerDelete, undo: undo}, op.Path)
+	case "replace":
+		return d.access(&access{op: pointerReplace, value: op.Value, undo: undo}, op.Path)
+	case "test":
+		value, err := d.genericAt(op.Path)
+		if err != nil {
+			return err
+		}
+		if !equalValues(value, true, op.Value, true) {
+			return errors.New("test failed, values differ")
+		}
+		return nil
+	}
+
+	// move and copy
+	if op.Op == "move" && op.Path == op.From {
+		return nil
+	}
+	if op.Op == "move" && strings.HasPrefix(op.Path, op.From+"/") {
+		return fmt.Errorf("cannot move %q into itself", op.From)
+	}
+	value, err := d.genericAt(op.From)
+	if err != nil {
+		return err
+	}
+	if op.Op == "move" {
+		if err := d.access(&access{op: pointerDelete, undo: undo}, op.From); err != nil {
+			return err
+		}
+	}
+	return d.access(&access{op: pointerAdd, value: value, undo: undo}, op.Path)
+}
+
+// genericAt returns a copy of the value at the JSON Pointer as a tree of
+// *OrderedMap, []interface{} and scalars
+func (d *Document[T]) genericAt(pointer string) (interface{}, error) {
+	a := &access{op: pointerGet}
+	if err := d.access(a, pointer); err != nil {
+		return nil, err
+	}
+	_, value, err := toGeneric(a.value)
+	return value, err
+}
@@ -0,0 +1,504 @@
+package jsonedit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped reference
+// tokens. The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("jsonedit: invalid JSON pointer %q: must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		if !strings.Contains(token, "~") {
+			continue
+		}
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || token[j+1] != '0' && token[j+1] != '1') {
+				return nil, fmt.Errorf("jsonedit: invalid JSON pointer %q: bad escape in %q", pointer, token)
+			}
+		}
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// formatPointer joins reference tokens into a JSON Pointer
+func formatPointer(tokens []string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return b.String()
+}
+
+// parseIndex parses a reference token as array index, which must not have a
+// sign or leading zeros
+func parseIndex(token string) (int, bool) {
+	if token == "" || len(token) > 1 && token[0] == '0' {
+		return 0, false
+	}
+	for i := 0; i < len(token); i++ {
+		if !isDigit(token[i]) {
+			return 0, false
+		}
+	}
+	i, err := strconv.Atoi(token)
+	return i, err == nil
+}
+
+var orderedMapType = reflect.TypeFor[*OrderedMap]()
+
+// pointerOp is an operation on the value a JSON Pointer refers to
+type pointerOp int
+
+const (
+	pointerGet pointerOp = iota
+	pointerSet
+	// pointerAdd inserts into arrays and does not create missing parents
+	pointerAdd
+	// pointerReplace requires the value to exist
+	pointerReplace
+	pointerDelete
+)
+
+// access carries out an operation on the value at a JSON Pointer. Typed data
+// is walked by reflection. Object keys a struct has no field for are looked
+// up in the original object at the same place, which is where the encoder
+// takes them from.
+type access struct {
+	op      pointerOp
+	pointer string
+	tokens  []string
+	// value is the value to set or the value found
+	value interface{}
+	// rest holds the keys of the root object the typed data has no field
+	// for, in place of the original object
+	rest *OrderedMap
+	// undo collects functions reverting the changes made, if not nil
+	undo *[]func()
+}
+
+func (a *access) notFound() error {
+	return fmt.Errorf("jsonedit: no value at %q", a.pointer)
+}
+
+// creates reports whether a missing value is created, parents are only
+// created by pointerSet
+func (a *access) creates(last bool) bool {
+	return a.op == pointerSet || last && a.op == pointerAdd
+}
+
+// record adds fn to the undo functions
+func (a *access) record(fn func()) {
+	if a.undo != nil {
+		*a.undo = append(*a.undo, fn)
+	}
+}
+
+// save records the current value of v to restore it on undo
+func (a *access) save(v reflect.Value) {
+	if a.undo == nil {
+		return
+	}
+	old := reflect.New(v.Type()).Elem()
+	old.Set(v)
+	a.record(func() { v.Set(old) })
+}
+
+// walk applies the operation to the value at tokens[i:] below v, which must
+// be settable. orig is the original value at the place of v.
+func (a *access) walk(v reflect.Value, orig interface{}, i int) error {
+	if i == len(a.tokens) {
+		if a.op == pointerGet {
+			a.value = v.Interface()
+			return nil
+		}
+		a.save(v)
+		return a.assign(v)
+	}
+
+	switch {
+	case v.Type() == orderedMapType:
+		return a.object(v, i)
+	case v.Kind() == reflect.Interface:
+		if v.IsNil() {
+			if a.op != pointerSet {
+				return a.notFound()
+			}
+			a.save(v)
+			v.Set(reflect.ValueOf(NewOrderedMap()))
+		}
+		// The value in an interface is not addressable, work on a copy
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := a.walk(elem, orig, i); err != nil {
+			return err
+		}
+		if a.op != pointerGet {
+			a.save(v)
+			v.Set(elem)
+		}
+		return nil
+	case v.Kind() == reflect.Pointer:
+		if v.IsNil() {
+			if a.op != pointerSet {
+				return a.notFound()
+			}
+			a.save(v)
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return a.walk(v.Elem(), orig, i)
+	}
+
+	if _, ok := marshalerOf(v); ok {
+		return fmt.Errorf("jsonedit: cannot address %q inside %s, which encodes itself", a.pointer, v.Type())
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return a.field(v, orig, i)
+	case reflect.Map:
+		return a.mapEntry(v, orig, i)
+	case reflect.Slice, reflect.Array:
+		return a.element(v, orig, i)
+	}
+	return a.notFound()
+}
+
+// object walks into a member of an *OrderedMap
+func (a *access) object(v reflect.Value, i int) error {
+	if v.IsNil() {
+		if a.op != pointerSet {
+			return a.notFound()
+		}
+		a.save(v)
+		v.Set(reflect.ValueOf(NewOrderedMap()))
+	}
+	om := v.Interface().(*OrderedMap)
+	token := a.tokens[i]
+	last := i+1 == len(a.tokens)
+
+	ov, ok := om.Values[token]
+	keys := om.Keys
+	switch {
+	case !ok && !a.creates(last):
+		return a.notFound()
+	case !ok && last:
+		om.Set(token, a.value, len(om.Keys))
+		a.record(func() { om.Keys = keys; delete(om.Values, token) })
+		return nil
+	case !ok:
+		om.Set(token, NewOrderedMap(), len(om.Keys))
+		a.record(func() { om.Keys = keys; delete(om.Values, token) })
+		ov = om.Values[token]
+	case last && a.op == pointerDelete:
+		om.Delete(token)
+		a.record(func() { om.Keys = keys; om.Values[token] = ov })
+		return nil
+	}
+	return a.walk(reflect.ValueOf(&ov.Value).Elem(), nil, i+1)
+}
+
+// field walks into a struct field, or into the original object for keys
+// without a field
+func (a *access) field(v reflect.Value, orig interface{}, i int) error {
+	token := a.tokens[i]
+	fields := cachedTypeFields(v.Type())
+	origMap, _ := orig.(*OrderedMap)
+
+	f := fields.lookup(token)
+	last := i+1 == len(a.tokens)
+	if f == nil {
+		if i == 0 && a.rest != nil {
+			origMap = a.rest
+		}
+		if origMap == nil {
+			if a.creates(last) {
+				return fmt.Errorf("jsonedit: cannot set %q, %s has no field for it", a.pointer, v.Type())
+			}
+			return a.notFound()
+		}
+		var o interface{} = origMap
+		return a.walk(reflect.ValueOf(&o).Elem(), nil, i)
+	}
+
+	fv := v
+	for _, x := range f.index {
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				if a.op != pointerSet || !fv.CanSet() {
+					return a.notFound()
+				}
+				a.save(fv)
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+		fv = fv.Field(x)
+	}
+
+	// Omitted fields are not in the document
+	if !a.creates(last) && f.omit(fv) {
+		return a.notFound()
+	}
+	if last && a.op == pointerDelete {
+		// A zeroed field stays in the document unless its tag omits it
+		if !f.omit(reflect.Zero(fv.Type())) {
+			return fmt.Errorf("jsonedit: cannot delete %q, %s always writes it", a.pointer, v.Type())
+		}
+		a.save(fv)
+		fv.SetZero()
+		return nil
+	}
+
+	// The last key matching the field is the one it was decoded from
+	var origVal interface{}
+	if origMap != nil {
+		for _, key := range origMap.Keys {
+			if fields.lookup(key) == f {
+				origVal = origMap.Values[key].Value
+			}
+		}
+	}
+	return a.walk(fv, origVal, i+1)
+}
+
+// mapEntry walks into an entry of a typed map
+func (a *access) mapEntry(v reflect.Value, orig interface{}, i int) error {
+	token := a.tokens[i]
+	t := v.Type()
+	if !isMapKey(t.Key()) {
+		return a.notFound()
+	}
+	d := &decoder{}
+	kv := reflect.New(t.Key()).Elem()
+	if !d.mapKey(token, kv) {
+		return d.savedError
+	}
+
+	last := i+1 == len(a.tokens)
+	elem := v.MapIndex(kv)
+	switch {
+	case !elem.IsValid() && (!a.creates(last) || v.IsNil() && a.op != pointerSet):
+		return a.notFound()
+	case !elem.IsValid():
+		if v.IsNil() {
+			a.save(v)
+			v.Set(reflect.MakeMap(t))
+		}
+		elem = reflect.Zero(t.Elem())
+	case last && a.op == pointerDelete:
+		a.record(func() { v.SetMapIndex(kv, elem) })
+		v.SetMapIndex(kv, reflect.Value{})
+		return nil
+	}
+
+	// Map elements are not addressable, work on a copy
+	cp := reflect.New(t.Elem()).Elem()
+	cp.Set(elem)
+	var origVal interface{}
+	if origMap, ok := orig.(*OrderedMap); ok {
+		origVal, _ = origMap.Get(token)
+	}
+	if err := a.walk(cp, origVal, i+1); err != nil {
+		return err
+	}
+	if a.op != pointerGet {
+		// Restores the entry or removes it if there was none
+		prev := v.MapIndex(kv)
+		a.record(func() { v.SetMapIndex(kv, prev) })
+		v.SetMapIndex(kv, cp)
+	}
+	return nil
+}
+
+// element walks into an element of a slice or array. The index "-" appends
+// to slices, pointerAdd inserts in front of the index.
+func (a *access) element(v reflect.Value, orig interface{}, i int) error {
+	token := a.tokens[i]
+	n := v.Len()
+	index, ok := n, token == "-"
+	if !ok {
+		index, ok = parseIndex(token)
+	}
+	if !ok {
+		return a.notFound()
+	}
+
+	last := i+1 == len(a.tokens)
+	switch {
+	case index < n && last && a.op == pointerDelete:
+		if v.Kind() == reflect.Array {
+			return fmt.Errorf("jsonedit: cannot delete %q from array of fixed length", a.pointer)
+		}
+		// Build a new slice, the old one may share its elements with the
+		// original document
+		a.save(v)
+		s := reflect.MakeSlice(v.Type(), 0, n-1)
+		s = reflect.AppendSlice(s, v.Slice(0, index))
+		v.Set(reflect.AppendSlice(s, v.Slice(index+1, n)))
+		return nil
+	case index <= n && last && a.op == pointerAdd:
+		if v.Kind() == reflect.Array {
+			return fmt.Errorf("jsonedit: cannot insert %q into array of fixed length", a.pointer)
+		}
+		a.save(v)
+		s := reflect.MakeSlice(v.Type(), 0, n+1)
+		s = reflect.AppendSlice(s, v.Slice(0, index))
+		s = reflect.Append(s, reflect.Zero(v.Type().Elem()))
+		v.Set(reflect.AppendSlice(s, v.Slice(index, n)))
+		// The original value at the index belongs to the moved element
+		return a.walk(v.Index(index), nil, i+1)
+	case index == n && a.op == pointerSet && v.Kind() == reflect.Slice:
+		a.save(v)
+		v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+	case index >= n:
+		return a.notFound()
+	}
+
+	var origVal interface{}
+	if arr, ok := orig.([]interface{}); ok && index < len(arr) {
+		origVal = arr[index]
+	}
+	return a.walk(v.Index(index), origVal, i+1)
+}
+
+// assign stores the value of a in v. Values of other types are converted by
+// encoding and decoding them.
+func (a *access) assign(v reflect.Value) error {
+	value := a.value
+	if value == nil {
+		v.SetZero()
+		return nil
+	}
+	if rv := reflect.ValueOf(value); rv.Type().AssignableTo(v.Type()) {
+		v.Set(rv)
+		return nil
+	}
+
+	tree, generic, err := toGeneric(value)
+	if err != nil {
+		return err
+	}
+	if v.Type() == orderedMapType {
+		om, ok := generic.(*OrderedMap)
+		if !ok {
+			return fmt.Errorf("jsonedit: cannot store %T in place of an object", value)
+		}
+		v.Set(reflect.ValueOf(om))
+		return nil
+	}
+	target := reflect.New(v.Type())
+	if err := decodeTree(tree, target.Interface()); err != nil {
+		var te *TypeError
+		if errors.As(err, &te) {
+			// The position is the one in the encoded value, report the
+			// place in the document instead
+			te.Path = a.pointer + te.Path
+			te.Offset, te.Line, te.Column = 0, 0, 0
+			te.err.Offset = 0
+		}
+		return err
+	}
+	v.Set(target.Elem())
+	return nil
+}
+
+// encodeCompact writes value as compact JSON
+func encodeCompact(w io.Writer, value interface{}) error {
+	ew := &errWriter{w: w}
+	ce := &customEncoder{w: ew, format: Format{Compact: true}}
+	if err := ce.encode(value, nil, ""); err != nil {
+		return err
+	}
+	return ew.err
+}
+
+// toGeneric converts value to a tree of *OrderedMap, []interface{} and
+// scalars by encoding it
+func toGeneric(value interface{}) (*syntaxTree, interface{}, error) {
+	var buf bytes.Buffer
+	if err := encodeCompact(&buf, value); err != nil {
+		return nil, nil, err
+	}
+	return parseTree(buf.Bytes(), options{})
+}
+
+// access carries out a on the document. The root is the typed data if it is
+// bound, with unknown keys of object roots in Rest. Original values are never
+// changed, edits go to the copy made by editable.
+func (d *Document[T]) access(a *access, pointer string) error {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return err
+	}
+	a.pointer, a.tokens = pointer, tokens
+
+	// Edits go to a copy of the original values
+	original := d.current()
+	if a.op != pointerGet {
+		original = *d.editable()
+	}
+
+	switch {
+	case isBound(d.TypedData):
+		a.rest = d.Rest
+		return a.walk(reflect.ValueOf(&d.TypedData).Elem(), original, 0)
+	case d.OriginalMap != nil:
+		if d.Rest == nil {
+			a.record(func() { d.Rest = nil })
+			d.Rest = original.(*OrderedMap)
+		}
+		return a.walk(reflect.ValueOf(&d.Rest).Elem(), nil, 0)
+	}
+	if a.op == pointerGet {
+		return a.walk(reflect.ValueOf(&original).Elem(), nil, 0)
+	}
+	return a.walk(reflect.ValueOf(d.editable()).Elem(), nil, 0)
+}
+
+// Get returns the value at the JSON Pointer as it would be written. Values
+// of typed data are returned as Go values, other values as *OrderedMap,
+// []interface{} or scalars.
+func (d *Document[T]) Get(pointer string) (interface{}, bool) {
+	a := &access{op: pointerGet}
+	if err := d.access(a, pointer); err != nil {
+		return nil, false
+	}
+	return a.value, true
+}
+
+// Has reports whether there is a value at the JSON Pointer
+func (d *Document[T]) Has(pointer string) bool {
+	_, ok := d.Get(pointer)
+	return ok
+}
+
+// Set replaces or adds the value at the JSON Pointer. Missing objects on the
+// way are created and the array index "-" appends. Values going into typed
+// data are converted to the Go type at that place as if encoded and decoded.
+func (d *Document[T]) Set(pointer string, value interface{}) error {
+	return d.access(&access{op: pointerSet, value: value}, pointer)
+}
+
+// Delete removes the value at the JSON Pointer. Struct fields are reset to
+// their zero value, which fails for fields whose tag does not omit it.
+func (d *Document[T]) Delete(pointer string) error {
+	if pointer == "" {
+		return errors.New("jsonedit: cannot delete the whole document")
+	}
+	return d.access(&access{op: pointerDelete}, pointer)
+}
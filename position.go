@@ -0,0 +1,130 @@
+package jsonedit
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Position is a location in the source of a document. Line and Column are
+// 1-based, Column counts bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Span is the source range of a key or value. End is exclusive.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// Location tells where a value is in the source and, for object members,
+// where its key is. Key is zero for array elements and the root.
+type Location struct {
+	Key   Span
+	Value Span
+}
+
+// position returns the 1-based line and byte column of offset in data
+func position(data []byte, offset int) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	return line, offset - bytes.LastIndexByte(data[:offset], '\n')
+}
+
+// lineIndex holds the offsets at which lines start, built on first use
+type lineIndex struct {
+	once   sync.Once
+	starts []int
+}
+
+// position returns the Position of offset in the source
+func (t *syntaxTree) position(offset int) Position {
+	t.lines.once.Do(func() {
+		t.lines.starts = []int{0}
+		for i, c := range t.data {
+			if c == '\n' {
+				t.lines.starts = append(t.lines.starts, i+1)
+			}
+		}
+	})
+	line := sort.SearchInts(t.lines.starts, offset+1)
+	return Position{Offset: offset, Line: line, Column: offset - t.lines.starts[line-1] + 1}
+}
+
+func (t *syntaxTree) span(start, end int) Span {
+	return Span{Start: t.position(start), End: t.position(end)}
+}
+
+// location returns the Location of n, the value of m unless it is the root
+func (t *syntaxTree) location(m *member, n *node) Location {
+	loc := Location{Value: t.span(n.offset, n.end)}
+	if m != nil && m.rawKey != "" {
+		loc.Key = t.span(m.keyOffset, m.keyOffset+len(m.rawKey))
+	}
+	return loc
+}
+
+// Locate returns where the value at the JSON Pointer is in the parsed source.
+// Changes made after parsing are not taken into account.
+func (d *Document[T]) Locate(pointer string) (Location, bool) {
+	tokens, err := parsePointer(pointer)
+	if err != nil || d.tree == nil {
+		return Location{}, false
+	}
+
+	var m *member
+	n := d.tree.root
+	for _, token := range tokens {
+		switch n.kind {
+		case kindObject:
+			m, _ = n.lookup(token)
+		case kindArray:
+			i, ok := parseIndex(token)
+			if !ok {
+				return Location{}, false
+			}
+			m = n.element(i)
+		default:
+			m = nil
+		}
+		if m == nil {
+			return Location{}, false
+		}
+		n = m.value
+	}
+	return d.tree.location(m, n), true
+}
+
+// Locations returns the Location of every value in the parsed source by its
+// JSON Pointer. Of duplicate keys the last one is used, like in decoding.
+func (d *Document[T]) Locations() map[string]Location {
+	locs := make(map[string]Location)
+	if d.tree == nil {
+		return locs
+	}
+
+	var walk func(pointer string, m *member, n *node)
+	walk = func(pointer string, m *member, n *node) {
+		locs[pointer] = d.tree.location(m, n)
+		for i, child := range n.members {
+			token := child.key
+			if n.kind == kindArray {
+				token = strconv.Itoa(i)
+			}
+			walk(pointer+formatPointer([]string{token}), child, child.value)
+		}
+	}
+	walk("", nil, d.tree.root)
+	return locs
+}
@@ -0,0 +1,760 @@
+package jsonedit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Editor applies edits addressed by JSON Pointer (RFC 6901) to a document
+// while streaming it from a reader to a writer. The document is validated and
+// copied token by token without building an OrderedMap, so memory use does
+// not grow with its size. Every byte outside of the edited values is written
+// as it was read.
+type Editor struct {
+	// Format is used to write new values. NewEditor sets it to compact output.
+	// Spaces after colons and commas follow the input once it has shown them.
+	Format Format
+
+	opts  options
+	edits *edit
+}
+
+// editOp is the operation of an edit
+type editOp int
+
+const (
+	editNone editOp = iota
+	editSet
+	editDelete
+)
+
+// edit is a node in the tree of edits. Nodes without an operation lead to
+// edits further down.
+type edit struct {
+	op    editOp
+	value interface{}
+	path  string
+
+	children map[string]*edit
+	order    []string
+
+	// seen is set once the addressed member was found in the input
+	seen    bool
+	applied bool
+}
+
+// NewEditor creates an Editor for documents in the dialect given by opts
+func NewEditor(opts ...Option) *Editor {
+	e := &Editor{
+		Format: Format{Compact: true},
+		edits:  &edit{},
+	}
+	for _, opt := range opts {
+		opt(&e.opts)
+	}
+	return e
+}
+
+// Set replaces the value at pointer. A missing object member is appended to
+// its object, creating missing parent objects on the way, and the array index
+// "-" appends an element.
+func (e *Editor) Set(pointer string, value interface{}) error {
+	return e.add(pointer, editSet, value)
+}
+
+// Delete removes the object member or array element at pointer
+func (e *Editor) Delete(pointer string) error {
+	if pointer == "" {
+		return errors.New("jsonedit: cannot delete the whole document")
+	}
+	return e.add(pointer, editDelete, nil)
+}
+
+func (e *Editor) add(pointer string, op editOp, value interface{}) error {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return err
+	}
+
+	ed := e.edits
+	for _, token := range tokens {
+		if ed.op != editNone {
+			return fmt.Errorf("jsonedit: edit of %q is inside the edit of %q", pointer, ed.path)
+		}
+		ed = ed.child(token)
+	}
+	// An edit replaces all edits below it
+	ed.op, ed.value = op, value
+	ed.children, ed.order = nil, nil
+	return nil
+}
+
+// child returns the child edit for token, creating it if needed
+func (ed *edit) child(token string) *edit {
+	if c, ok := ed.children[token]; ok {
+		return c
+	}
+	if ed.children == nil {
+		ed.children = make(map[string]*edit)
+	}
+	c := &edit{path: ed.path + formatPointer([]string{token})}
+	ed.children[token] = c
+	ed.order = append(ed.order, token)
+	return c
+}
+
+// lookup returns the child edit for token or nil
+func (ed *edit) lookup(token string) *edit {
+	if ed == nil {
+		return nil
+	}
+	c := ed.children[token]
+	if c != nil {
+		c.seen = true
+	}
+	return c
+}
+
+// reset clears the state of a previous run
+func (ed *edit) reset() {
+	ed.seen, ed.applied = false, false
+	for _, c := range ed.children {
+		c.reset()
+	}
+}
+
+// build returns the value inserted for a member that is missing in the
+// input: the value of a set or an object holding the sets below it
+func (ed *edit) build() (interface{}, bool) {
+	switch ed.op {
+	case editSet:
+		ed.applied = true
+		return ed.value, true
+	case editDelete:
+		return nil, false
+	}
+
+	om := NewOrderedMap()
+	for _, token := range ed.order {
+		if v, ok := ed.children[token].build(); ok {
+			om.Set(token, v, len(om.Keys))
+		}
+	}
+	return om, len(om.Keys) > 0
+}
+
+// unapplied returns an error for the first edit whose value was not found
+func (ed *edit) unapplied() error {
+	if ed.op != editNone && !ed.applied {
+		return fmt.Errorf("jsonedit: no value at %q", ed.path)
+	}
+	for _, token := range ed.order {
+		if err := ed.children[token].unapplied(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply reads a document from r, applies the edits and writes the result to
+// w. Pointers refer to the input document, so deleting an array element does
+// not shift the indices of other edits. When an error is returned w may have
+// received part of the output.
+func (e *Editor) Apply(w io.Writer, r io.Reader) error {
+	e.edits.reset()
+	out := bufio.NewWriter(w)
+	s := &streamer{
+		r:         bufio.NewReader(r),
+		streamPos: streamPos{line: 1},
+		out:       out,
+		opts:      e.opts,
+		format:    e.Format,
+	}
+
+	lead := s.trivia()
+	s.emit(lead)
+	if err := s.value(e.edits, lineIndent(lead, ""), "after top-level value"); err != nil {
+		return err
+	}
+	s.emit(s.trivia())
+	if c, ok := s.peek(); ok {
+		return s.errorf("invalid character %s after top-level value", quoteChar(c))
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	return e.edits.unapplied()
+}
+
+// streamer reads a document token by token and copies it to out
+type streamer struct {
+	r      *bufio.Reader
+	out    io.Writer
+	opts   options
+	format Format
+
+	streamPos
+	// scan collects the spacing of the input read so far
+	scan formatScan
+	// path holds the object keys and array indices leading to the value
+	// being streamed, for errors
+	path []string
+	// err is the first error returned by the reader
+	err error
+}
+
+// streamPos is a position in the input
+type streamPos struct {
+	pos       int // bytes consumed
+	line      int
+	lineStart int // offset of the first byte of line
+}
+
+// container tracks the members of the object or array being streamed
+type container struct {
+	seen  int // members read
+	count int // members written
+	// after describes the members for errors
+	after string
+
+	// held are comments following a comma whose member was deleted. They are
+	// written after the next comma or in front of the closing bracket.
+	held string
+	// firstLead is the layout of the lead of a deleted first member, reused
+	// by the member that moves to the front
+	firstLead  string
+	reuseFirst bool
+	// dropped is set if the last member read was deleted, the comments
+	// trailing it start the lead of the next member
+	dropped bool
+
+	lastLead  string
+	lastColon string
+	colonSeen bool
+	// sepLead is the lead of the last member that followed a comma
+	sepLead string
+	sepSeen bool
+}
+
+func (s *streamer) peek() (byte, bool) {
+	b, err := s.r.Peek(1)
+	if err != nil {
+		if err != io.EOF && s.err == nil {
+			s.err = err
+		}
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (s *streamer) read() byte {
+	c, _ := s.r.ReadByte()
+	s.pos++
+	if c == '\n' {
+		s.line++
+		s.lineStart = s.pos
+	}
+	return c
+}
+
+func (s *streamer) emit(text string) {
+	io.WriteString(s.out, text)
+}
+
+func (s *streamer) encoder() *customEncoder {
+	return &customEncoder{w: s.out, format: s.spacing(), json5: s.opts.json5()}
+}
+
+// spacing returns the Format with the spaces after colons and commas and the
+// line breaks seen in the input so far
+func (s *streamer) spacing() Format {
+	format := s.format
+	if s.scan.newline {
+		format.CRLF = s.scan.crlf
+	}
+	if s.scan.colonSeen {
+		format.SpaceAfterColon = s.scan.spaceAfterColon
+		format.SpaceAfterComma = s.scan.spaceAfterColon
+	}
+	if s.scan.commaSeen {
+		format.SpaceAfterComma = s.scan.spaceAfterComma
+	}
+	return format
+}
+
+func (s *streamer) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{
+		Msg:    fmt.Sprintf(format, args...),
+		Offset: s.pos,
+		Line:   s.line,
+		Column: s.pos - s.lineStart + 1,
+		Path:   formatPointer(s.path),
+	}
+}
+
+// relocate moves the position of a SyntaxError from a token parsed on its own
+// to the place of the token in the input
+func (s *streamer) relocate(err error, at streamPos) error {
+	se, ok := err.(*SyntaxError)
+	if !ok {
+		return err
+	}
+	if se.Line == 1 {
+		se.Column += at.pos - at.lineStart
+	}
+	se.Line += at.line - 1
+	se.Offset += at.pos
+	se.Path = formatPointer(s.path)
+	return se
+}
+
+func (s *streamer) unexpected(context string) error {
+	c, ok := s.peek()
+	if !ok {
+		if s.err != nil {
+			return s.err
+		}
+		return s.errorf("unexpected end of JSON input")
+	}
+	return s.errorf("invalid character %s %s", quoteChar(c), context)
+}
+
+// trivia consumes whitespace and, if the dialect allows it, comments
+func (s *streamer) trivia() string {
+	var b strings.Builder
+	for {
+		c, ok := s.peek()
+		if !ok {
+			return b.String()
+		}
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			b.WriteByte(s.read())
+			if c == '\n' {
+				s.scan.lineBreak(b.String())
+			}
+		case c == '/' && s.opts.comments():
+			next, _ := s.r.Peek(2)
+			if len(next) < 2 || next[1] != '/' && next[1] != '*' {
+				return b.String()
+			}
+			block := next[1] == '*'
+			b.WriteByte(s.read())
+			b.WriteByte(s.read())
+			for {
+				c, ok := s.peek()
+				if !ok || !block && c == '\n' {
+					break
+				}
+				b.WriteByte(s.read())
+				if block && c == '*' {
+					if c, ok := s.peek(); ok && c == '/' {
+						b.WriteByte(s.read())
+						break
+					}
+				}
+			}
+		case c >= utf8.RuneSelf && s.opts.json5():
+			r, size, _ := s.r.ReadRune()
+			if !json5Space(r) {
+				s.r.UnreadRune()
+				return b.String()
+			}
+			s.pos += size
+			b.WriteRune(r)
+		default:
+			return b.String()
+		}
+	}
+}
+
+// token reads bytes while more reports true for them
+func (s *streamer) token(more func(c byte) bool) []byte {
+	var b []byte
+	for {
+		c, ok := s.peek()
+		if !ok || !more(c) {
+			return b
+		}
+		b = append(b, s.read())
+	}
+}
+
+// string reads a string token and returns its raw text and value
+func (s *streamer) string() (string, string, error) {
+	at := s.streamPos
+	quote := s.read()
+	tok := []byte{quote}
+	for {
+		c, ok := s.peek()
+		if !ok {
+			return "", "", s.unexpected("in string literal")
+		}
+		tok = append(tok, s.read())
+		if c == quote {
+			break
+		}
+		if c == '\\' {
+			if _, ok := s.peek(); ok {
+				tok = append(tok, s.read())
+			}
+		}
+	}
+
+	p := &parser{data: tok, opts: s.opts}
+	var value string
+	var err error
+	if s.opts.json5() {
+		value, _, err = p.parseString5()
+	} else {
+		value, _, err = p.parseString()
+	}
+	if err != nil {
+		return "", "", s.relocate(err, at)
+	}
+	return string(tok), value, nil
+}
+
+// isDelimiter reports whether c ends a number or literal token
+func isDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', ',', ':', '[', ']', '{', '}', '"', '\'', '/':
+		return true
+	}
+	return c >= utf8.RuneSelf
+}
+
+// scalar reads a number or literal token. after describes what the token is
+// for errors about characters following it.
+func (s *streamer) scalar(after string) (string, error) {
+	at := s.streamPos
+	tok := s.token(func(c byte) bool { return !isDelimiter(c) })
+	if len(tok) == 0 {
+		return "", s.unexpected("looking for beginning of value")
+	}
+
+	// Parse the token with the delimiter that ended it, so that a truncated
+	// token is reported at the offending character
+	data := tok
+	if c, ok := s.peek(); ok {
+		data = append(tok[:len(tok):len(tok)], c)
+	}
+	p := &parser{data: data, opts: s.opts}
+	if _, _, err := p.parseValue(); err != nil {
+		return "", s.relocate(err, at)
+	}
+	if p.pos < len(tok) {
+		return "", s.relocate(p.unexpected(after), at)
+	}
+	return string(tok), nil
+}
+
+// key reads an object key and returns its raw text and value. JSON5
+// whitespace read as part of an identifier is returned as trivia.
+func (s *streamer) key() (string, string, string, error) {
+	c, ok := s.peek()
+	switch {
+	case ok && (c == '"' || c == '\'' && s.opts.json5()):
+		raw, key, err := s.string()
+		return raw, key, "", err
+	case ok && s.opts.json5():
+		at := s.streamPos
+		tok := s.token(func(c byte) bool {
+			return c >= utf8.RuneSelf || !isDelimiter(c)
+		})
+		if len(tok) == 0 {
+			return "", "", "", s.unexpected("looking for beginning of object key")
+		}
+		p := &parser{data: tok, opts: s.opts}
+		key, raw, err := p.parseIdentifier()
+		if err != nil {
+			return "", "", "", s.relocate(err, at)
+		}
+		rest := p.scanTrivia()
+		if p.pos < len(tok) {
+			return "", "", "", s.relocate(p.unexpected("after object key"), at)
+		}
+		return raw, key, rest, nil
+	}
+	return "", "", "", s.unexpected("looking for beginning of object key string")
+}
+
+// skip consumes a value without writing it
+func (s *streamer) skip(after string) error {
+	out := s.out
+	s.out = io.Discard
+	err := s.value(nil, "", after)
+	s.out = out
+	return err
+}
+
+// value streams a value and applies the edits in ed. after describes the
+// value for errors, like "after array element".
+func (s *streamer) value(ed *edit, indent, after string) error {
+	if ed != nil && ed.op == editSet {
+		ed.applied = true
+		if err := s.skip(after); err != nil {
+			return err
+		}
+		return s.encoder().encode(ed.value, nil, indent)
+	}
+
+	c, ok := s.peek()
+	switch {
+	case !ok:
+		return s.unexpected("looking for beginning of value")
+	case c == '{':
+		return s.object(ed, indent)
+	case c == '[':
+		return s.array(ed, indent)
+	case c == '"' || c == '\'' && s.opts.json5():
+		raw, _, err := s.string()
+		if err != nil {
+			return err
+		}
+		s.emit(raw)
+	default:
+		raw, err := s.scalar(after)
+		if err != nil {
+			return err
+		}
+		s.emit(raw)
+	}
+	return nil
+}
+
+func (s *streamer) object(ed *edit, indent string) error {
+	s.read()
+	s.emit("{")
+
+	c := container{after: "after object key:value pair"}
+	for {
+		lead := s.trivia()
+		if b, ok := s.peek(); ok && b == '}' && (c.seen == 0 || s.opts.allowTrailingCommas()) {
+			s.read()
+			return s.close(ed, &c, lead, c.seen > 0, indent, '}')
+		}
+
+		rawKey, key, preColon, err := s.key()
+		if err != nil {
+			return err
+		}
+		preColon += s.trivia()
+		if b, ok := s.peek(); !ok || b != ':' {
+			return s.unexpected("after object key")
+		}
+		s.read()
+		postColon := s.trivia()
+		c.lastColon, c.colonSeen = postColon, true
+		s.scan.colon(postColon)
+
+		s.path = append(s.path, key)
+		kept, err := s.member(ed.lookup(key), &c, lead, rawKey+preColon+":"+postColon, indent)
+		if err != nil {
+			return err
+		}
+		s.path = s.path[:len(s.path)-1]
+
+		after := s.trivia()
+		b, ok := s.peek()
+		switch {
+		case ok && b == ',':
+			s.read()
+			if kept {
+				s.emit(after)
+			}
+		case ok && b == '}':
+			s.read()
+			return s.close(ed, &c, s.closeTrail(&c, kept, after), false, indent, '}')
+		default:
+			return s.unexpected(c.after)
+		}
+	}
+}
+
+func (s *streamer) array(ed *edit, indent string) error {
+	s.read()
+	s.emit("[")
+
+	c := container{after: "after array element"}
+	for {
+		lead := s.trivia()
+		if b, ok := s.peek(); ok && b == ']' && (c.seen == 0 || s.opts.allowTrailingCommas()) {
+			s.read()
+			return s.close(ed, &c, lead, c.seen > 0, indent, ']')
+		}
+
+		index := strconv.Itoa(c.seen)
+		s.path = append(s.path, index)
+		kept, err := s.member(ed.lookup(index), &c, lead, "", indent)
+		if err != nil {
+			return err
+		}
+		s.path = s.path[:len(s.path)-1]
+
+		after := s.trivia()
+		b, ok := s.peek()
+		switch {
+		case ok && b == ',':
+			s.read()
+			if kept {
+				s.emit(after)
+			}
+		case ok && b == ']':
+			s.read()
+			return s.close(ed, &c, s.closeTrail(&c, kept, after), false, indent, ']')
+		default:
+			return s.unexpected(c.after)
+		}
+	}
+}
+
+// member streams the value of an object member or array element whose lead
+// and key have been read. It reports whether the member was kept.
+func (s *streamer) member(ed *edit, c *container, lead, key, indent string) (bool, error) {
+	if c.seen > 0 {
+		c.sepLead, c.sepSeen = lead, true
+	}
+	s.scan.member(lead, c.seen, len(s.path))
+	afterComma := c.seen > 0
+	c.seen++
+
+	if ed != nil && ed.op == editDelete {
+		ed.applied = true
+		if c.count > 0 {
+			// Comments after the comma belong to the previous member
+			if !c.dropped {
+				trail, _ := splitTrail(lead)
+				c.held += trail
+			}
+		} else if !c.reuseFirst {
+			c.firstLead, c.reuseFirst = layout(lead), true
+		}
+		c.dropped = true
+		return false, s.skip(c.after)
+	}
+
+	if afterComma && c.dropped {
+		// Drop the comments trailing the deleted member
+		_, lead = splitTrail(lead)
+	}
+	if c.count == 0 && c.reuseFirst && len(comments(lead)) == 0 {
+		lead = c.firstLead
+	}
+	c.dropped = false
+	s.separate(c)
+	s.emit(lead)
+	s.emit(key)
+	if err := s.value(ed, lineIndent(lead, indent), c.after); err != nil {
+		return false, err
+	}
+	c.count++
+	c.lastLead = lead
+	return true, nil
+}
+
+// separate writes the comma in front of a member that follows another one
+func (s *streamer) separate(c *container) {
+	if c.count > 0 {
+		s.emit(",")
+		s.emit(c.held)
+		c.held = ""
+	}
+}
+
+// closeTrail writes the comments trailing the last member, unless it was
+// deleted, and returns the trivia in front of the closing bracket
+func (s *streamer) closeTrail(c *container, kept bool, after string) string {
+	trail, closeLead := splitTrail(after)
+	if kept {
+		s.emit(trail)
+	}
+	return closeLead
+}
+
+// close appends members that are missing in the input and writes the
+// closing bracket
+func (s *streamer) close(ed *edit, c *container, closeLead string, trailingComma bool, indent string, bracket byte) error {
+	if ed != nil {
+		for _, token := range ed.order {
+			child := ed.children[token]
+			if child.seen || bracket == ']' && token != "-" {
+				continue
+			}
+			value, ok := child.build()
+			if !ok {
+				continue
+			}
+
+			lead := s.newLead(c, closeLead, indent)
+			if !strings.Contains(closeLead, "\n") && strings.Contains(lead, "\n") {
+				closeLead = s.spacing().newline() + indent
+			}
+			s.separate(c)
+			s.emit(lead)
+			ce := s.encoder()
+			if bracket == '}' {
+				if err := ce.encodeKey(token); err != nil {
+					return err
+				}
+				s.emit(":")
+				switch {
+				case c.colonSeen:
+					s.emit(c.lastColon)
+				case s.spacing().SpaceAfterColon:
+					s.emit(" ")
+				}
+			}
+			if err := ce.encode(value, nil, lineIndent(lead, indent)); err != nil {
+				return err
+			}
+			c.count++
+			c.lastLead = lead
+		}
+	}
+
+	if trailingComma && c.count > 0 {
+		s.emit(",")
+	}
+	s.encoder().writeTrivia(c.held, closeLead)
+	s.emit(string(bracket))
+	return nil
+}
+
+// newLead returns the trivia in front of an appended member
+func (s *streamer) newLead(c *container, closeLead, indent string) string {
+	switch {
+	case c.sepSeen:
+		return layout(c.sepLead)
+	case c.count > 0 && strings.Contains(c.lastLead, "\n"):
+		return layout(c.lastLead)
+	case c.count > 0 && c.colonSeen:
+		// Like the format detection, take the spacing after a comma from the
+		// spacing after the colon
+		if strings.HasPrefix(c.lastColon, " ") {
+			return " "
+		}
+		return ""
+	case c.count > 0:
+		if s.spacing().SpaceAfterComma {
+			return " "
+		}
+		return ""
+	case c.reuseFirst:
+		return layout(c.firstLead)
+	case strings.Contains(closeLead, "\n"):
+		return s.spacing().newline() + lineIndent(closeLead, indent) + s.format.Indent
+	case !s.format.Compact:
+		return s.spacing().newline() + indent + s.format.Indent
+	}
+	return ""
+}